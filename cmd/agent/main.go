@@ -1,96 +1,162 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+	"github.com/pavelborisofff/go-metrics/internal/certs"
+	"github.com/pavelborisofff/go-metrics/internal/config"
+	"github.com/pavelborisofff/go-metrics/internal/crypto"
 	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/rpc"
 	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/subnet"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
 )
 
-const (
-	pollIntervalDef   = 2
-	reportIntervalDef = 10
-	serverAddrDef     = "localhost:8080"
-)
+var log = logger.GetLogger()
 
-var (
-	pollInterval   time.Duration
-	reportInterval time.Duration
-	serverAddr     string
-	log            = logger.GetLogger()
-)
+// loadConfig читает настройки, при -print-config печатает их и завершает работу.
+func loadConfig() *config.Agent {
+	cfg, err := config.LoadAgent(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	if cfg.Print {
+		if err = config.Print(os.Stdout, cfg.Redacted()); err != nil {
+			log.Fatal("Error printing config", zap.Error(err))
+		}
+		os.Exit(0)
+	}
+
+	if err = logger.Configure(cfg.LogOptions()); err != nil {
+		log.Fatal("Error configuring logger", zap.Error(err))
+	}
+	log.Info("Config loaded", zap.Any("config", cfg.Redacted()))
+	return cfg
+}
+
+func main() {
+	defer log.Sync()
+
+	s := storage.NewAgentStorage()
+	cfg := loadConfig()
 
-func ParseFlags() {
-	var (
-		err                error
-		serverAddrFlag     string
-		pollIntervalFlag   int
-		reportIntervalFlag int
-	)
-
-	flag.StringVar(&serverAddrFlag, "a", serverAddrDef, "Server address")
-	flag.IntVar(&pollIntervalFlag, "p", pollIntervalDef, "Poll interval")
-	flag.IntVar(&reportIntervalFlag, "r", reportIntervalDef, "Report interval")
-	flag.Parse()
-
-	serverAddrEnv, exists := os.LookupEnv("ADDRESS")
-	if exists {
-		serverAddrFlag = serverAddrEnv
+	shutdownTracing, err := tracing.Setup("metrics-agent", cfg.TraceExporter, cfg.TraceEndpoint)
+	if err != nil {
+		log.Fatal("Error configuring tracing", zap.Error(err))
 	}
-	serverAddr = fmt.Sprintf("http://%s", serverAddrFlag)
+	defer shutdownTracing(context.Background())
+	serverAddr := cfg.ServerURL()
 
-	pollIntervalEnv, exists := os.LookupEnv("POLL_INTERVAL")
-	if exists {
-		pollIntervalFlag, err = strconv.Atoi(pollIntervalEnv)
+	if cfg.CryptoKey != "" {
+		key, err := crypto.LoadPublicKey(cfg.CryptoKey)
 		if err != nil {
-			log.Fatal("Error parsing POLL_INTERVAL", zap.Error(err))
+			log.Fatal("Error loading crypto key", zap.Error(err))
 		}
+		s.PublicKey = key
 	}
-	pollInterval = time.Duration(pollIntervalFlag) * time.Second
-
-	if pollInterval < time.Duration(1)*time.Second {
-		log.Fatal("Poll interval must be >= 1s")
+	s.Token = cfg.Token
+	s.Encoding = cfg.Compress
+	if host, err := os.Hostname(); err == nil {
+		s.AgentID = host
 	}
 
-	reportIntervalEnv, exists := os.LookupEnv("REPORT_INTERVAL")
-	if exists {
-		reportIntervalFlag, err = strconv.Atoi(reportIntervalEnv)
+	var grpcOpts []grpc.DialOption
+	if strings.HasPrefix(serverAddr, "https://") {
+		tlsConfig, err := certs.ClientConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey)
 		if err != nil {
-			log.Fatal("Error parsing REPORT_INTERVAL", zap.Error(err))
+			log.Fatal("Error configuring TLS", zap.Error(err))
 		}
+		s.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		grpcOpts = append(grpcOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	}
-	reportInterval = time.Duration(reportIntervalFlag) * time.Second
 
-	if reportInterval < time.Duration(1)*time.Second {
-		log.Fatal("Report interval must be >= 1s")
+	// IP, с которого агент ходит к серверу, нужен для проверки доверенной подсети
+	u, err := url.Parse(serverAddr)
+	if err != nil {
+		log.Fatal("Error parsing server address", zap.Error(err))
+	}
+	target := u.Host
+	if cfg.GRPCAddress != "" {
+		target = cfg.GRPCAddress
+	}
+	if ip, err := subnet.OutboundIP(target); err != nil {
+		log.Warn("Error detecting outbound IP", zap.Error(err))
+	} else {
+		s.RealIP = ip.String()
 	}
 
-	msg := fmt.Sprintf("\nServer address: %s\nPoll interval: %v\nReport interval: %v", serverAddr, pollInterval, reportInterval)
-	log.Info(msg)
-}
+	send := func(ctx context.Context) error {
+		return s.SendJSONMetrics(ctx, serverAddr)
+	}
 
-func main() {
-	defer log.Sync()
+	if cfg.GRPCAddress != "" {
+		// Токен читается при каждом вызове: он может смениться по SIGHUP
+		grpcOpts = append(grpcOpts, auth.WithToken(func() string { return s.Token }))
+		grpcOpts = append(grpcOpts, rpc.WithRealIP(s.RealIP), rpc.WithAgentID(s.AgentID))
+		c, err := rpc.NewClient(cfg.GRPCAddress, grpcOpts...)
+		if err != nil {
+			log.Fatal("Error creating gRPC client", zap.Error(err))
+		}
+		defer c.Close()
 
-	s := storage.NewAgentStorage()
-	ParseFlags()
+		send = func(ctx context.Context) error {
+			return c.SendMetrics(ctx, s.All())
+		}
+	}
 
-	pollTicker := time.NewTicker(pollInterval)
+	pollTicker := time.NewTicker(cfg.PollInterval.Std())
 	defer pollTicker.Stop()
-	reportTicker := time.NewTicker(reportInterval)
+	reportTicker := time.NewTicker(cfg.ReportInterval.Std())
 	defer reportTicker.Stop()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
 	for {
 		select {
+		case <-stop:
+			// Выходим через return, чтобы отложенные вызовы отправили спаны
+			log.Info("Agent stopped")
+			return
+		case <-hup:
+			next, err := reload(cfg, s)
+			if err != nil {
+				log.Error("Config reload rejected", zap.Error(err))
+				continue
+			}
+			pollTicker.Reset(next.PollInterval.Std())
+			reportTicker.Reset(next.ReportInterval.Std())
+			cfg = next
 		case <-pollTicker.C:
+			_, span := tracing.Start(context.Background(), "agent.collect")
 			s.UpdateMetrics()
+			span.End()
 		case <-reportTicker.C:
-			err := s.SendJSONMetrics(serverAddr)
+			ctx, span := tracing.Start(context.Background(), "agent.report")
+			err := send(ctx)
+			tracing.End(span, err)
 			if err != nil {
 				log.Error("Error sending metrics", zap.Error(err))
 			}
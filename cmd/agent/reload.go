@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/pavelborisofff/go-metrics/internal/config"
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+// reload перечитывает конфигурацию и применяет к хранилищу то, что меняется
+// без перезапуска. Интервалы тикеров обновляет вызывающий.
+func reload(cur *config.Agent, s *storage.AgentStorage) (*config.Agent, error) {
+	next, err := config.LoadAgent(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if changed := config.RestartRequired(cur, next); len(changed) > 0 {
+		return nil, fmt.Errorf("%w: %s", config.ErrRestartRequired, strings.Join(changed, ", "))
+	}
+	if err = logger.Configure(next.LogOptions()); err != nil {
+		return nil, err
+	}
+
+	s.Token = next.Token
+	s.Encoding = next.Compress
+
+	log.Info("Config reloaded", zap.Any("config", next.Redacted()))
+	return next, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pavelborisofff/go-metrics/internal/certs"
+	"github.com/pavelborisofff/go-metrics/internal/config"
+	"github.com/pavelborisofff/go-metrics/internal/export"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+const exportUsage = `Usage: server export [flags]
+
+Выгружает метрики в CSV или NDJSON. Без -addr читает файл хранилища
+сервера, в нём есть только текущие значения. Путь берётся из настроек
+сервера (-c, FILE_STORAGE_PATH, -f). С -addr берёт данные у запущенного
+сервера через /api/v1/export, там доступна и история (-history). Токен
+и TLS настраиваются как у агента (-c, API_TOKEN, TLS_CA, ...).
+
+`
+
+// Флаги export, которые передаются в config как есть. Остальные
+// настройки (файл конфигурации, окружение, умолчания) config берёт сам.
+var (
+	exportServerFlags = map[string]string{"c": "c", "f": "f"}
+	exportAgentFlags  = map[string]string{
+		"c":        "c",
+		"addr":     "a",
+		"token":    "token",
+		"tls-ca":   "tls-ca",
+		"tls-cert": "tls-cert",
+		"tls-key":  "tls-key",
+	}
+)
+
+// runExport - подкоманда export, возвращает код выхода.
+func runExport(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, exportUsage)
+		fs.PrintDefaults()
+	}
+
+	fs.String("c", "", "Config file: server's without -addr, agent's with -addr (env CONFIG)")
+	fs.String("f", "", "Metrics file to export from (env FILE_STORAGE_PATH)")
+	addr := fs.String("addr", "", "Server address to export from instead of the file")
+	fs.String("token", "", "Bearer token for -addr (env API_TOKEN)")
+	fs.String("tls-ca", "", "CA bundle (PEM) to verify the server (env TLS_CA)")
+	fs.String("tls-cert", "", "Client certificate (PEM) for mTLS (env TLS_CERT)")
+	fs.String("tls-key", "", "Client private key (PEM) for mTLS (env TLS_KEY)")
+	format := fs.String("format", export.FormatCSV, "Output format: csv or ndjson")
+	history := fs.Bool("history", false, "Export history samples instead of current values (-addr only)")
+	prefix := fs.String("prefix", "", "Only metrics with this name prefix")
+	from := fs.String("from", "", "History start, RFC 3339 or Unix seconds")
+	to := fs.String("to", "", "History end, RFC 3339 or Unix seconds")
+	out := fs.String("o", "", "Output file, stdout by default")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if *format != export.FormatCSV && *format != export.FormatNDJSON {
+		fmt.Fprintf(stderr, "unknown format: %s\n", *format)
+		return 2
+	}
+	f := export.Filter{Prefix: *prefix}
+	var err error
+	if f.From, err = export.ParseTime(*from); err != nil {
+		fmt.Fprintf(stderr, "bad -from: %v\n", err)
+		return 2
+	}
+	if f.To, err = export.ParseTime(*to); err != nil {
+		fmt.Fprintf(stderr, "bad -to: %v\n", err)
+		return 2
+	}
+	if *history && *addr == "" {
+		fmt.Fprintln(stderr, "history is kept in server memory only, -history requires -addr")
+		return 2
+	}
+
+	w := stdout
+	if *out != "" {
+		of, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer of.Close()
+		w = of
+	}
+
+	// Флаги передаются в config как есть, чтобы работали -c и окружение
+	forward := func(names map[string]string) []string {
+		var args []string
+		fs.Visit(func(fl *flag.Flag) {
+			if to, ok := names[fl.Name]; ok {
+				args = append(args, "-"+to+"="+fl.Value.String())
+			}
+		})
+		return args
+	}
+
+	if *addr != "" {
+		err = exportRemote(w, forward(exportAgentFlags), *format, *history, *prefix, *from, *to)
+	} else {
+		err = exportFile(w, forward(exportServerFlags), *format, f)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// exportFile выгружает текущие значения из файла хранилища, путь к
+// которому берётся из настроек сервера.
+func exportFile(w io.Writer, args []string, format string, f export.Filter) error {
+	cfg, err := config.LoadServer(args)
+	if err != nil {
+		return err
+	}
+
+	s := storage.NewMemStorage()
+	if err = s.Restore(context.Background(), cfg.StoreFile); err != nil {
+		return err
+	}
+	return export.WriteAll(w, format, export.Values(s, f, time.Now()))
+}
+
+// exportRemote копирует в w ответ /api/v1/export сервера. Адрес, токен
+// и TLS берутся из настроек агента.
+func exportRemote(w io.Writer, args []string, format string, history bool, prefix, from, to string) error {
+	cfg, err := config.LoadAgent(args)
+	if err != nil {
+		return err
+	}
+
+	addr := cfg.ServerURL()
+	client := &http.Client{}
+	if strings.HasPrefix(addr, "https://") {
+		tlsConfig, err := certs.ClientConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return err
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	kind := "values"
+	if history {
+		kind = "history"
+	}
+
+	q := url.Values{"format": {format}}
+	for k, v := range map[string]string{"prefix": prefix, "from": from, "to": to} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/api/v1/export/"+kind+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("server responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -1,114 +1,323 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+	"github.com/pavelborisofff/go-metrics/internal/certs"
+	"github.com/pavelborisofff/go-metrics/internal/config"
+	"github.com/pavelborisofff/go-metrics/internal/crypto"
+	"github.com/pavelborisofff/go-metrics/internal/handlers"
+	"github.com/pavelborisofff/go-metrics/internal/limit"
 	"github.com/pavelborisofff/go-metrics/internal/logger"
+	pb "github.com/pavelborisofff/go-metrics/internal/proto"
 	"github.com/pavelborisofff/go-metrics/internal/routers"
+	"github.com/pavelborisofff/go-metrics/internal/rpc"
+	"github.com/pavelborisofff/go-metrics/internal/selfmetrics"
 	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
+	"github.com/pavelborisofff/go-metrics/internal/subnet"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
 )
 
-const (
-	serverAddrDef   = "localhost:8080"
-	saveIntervalDef = 300
-	fileStoreDef    = "/tmp/metrics-db.json"
-	restoreDef      = true
-)
+const certReloadInterval = 10 * time.Second
 
-var (
-	ServerAddr   string
-	SaveInterval time.Duration
-	FileStore    string
-	Restore      bool
-	log          = logger.GetLogger()
-)
+var log = logger.GetLogger()
 
-func ParseFlags() {
-	var (
-		err              error
-		serverAddrFlag   string
-		saveIntervalFlag int
-		fileStoreFlag    string
-		restoreFlag      bool
-	)
-	flag.StringVar(&serverAddrFlag, "a", serverAddrDef, "Server address")
-	flag.IntVar(&saveIntervalFlag, "i", saveIntervalDef, "Save to file interval (sec)")
-	flag.StringVar(&fileStoreFlag, "f", fileStoreDef, "Server address")
-	flag.BoolVar(&restoreFlag, "r", restoreDef, "Restore metrics from storage")
-	flag.Parse()
-
-	// Server address
-	serverAddrEnv, exists := os.LookupEnv("ADDRESS")
-	if exists {
-		serverAddrFlag = serverAddrEnv
-	}
-
-	ServerAddr = serverAddrFlag
-
-	// Store interval
-	saveIntervalEnv, exists := os.LookupEnv("STORE_INTERVAL")
-	if exists {
-		saveIntervalFlag, err = strconv.Atoi(saveIntervalEnv)
-		if err != nil {
-			log.Fatal("Error parsing STORE_INTERVAL", zap.Error(err))
+// loadConfig читает настройки, при -print-config печатает их и завершает работу.
+func loadConfig() *config.Server {
+	cfg, err := config.LoadServer(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	if cfg.Print {
+		if err = config.Print(os.Stdout, cfg); err != nil {
+			log.Fatal("Error printing config", zap.Error(err))
 		}
+		os.Exit(0)
 	}
-	SaveInterval = time.Duration(saveIntervalFlag) * time.Second
 
-	// Store file path
-	fileStoreEnv, exists := os.LookupEnv("FILE_STORAGE_PATH")
-	if exists {
-		fileStoreFlag = fileStoreEnv
+	if err = logger.Configure(cfg.LogOptions()); err != nil {
+		log.Fatal("Error configuring logger", zap.Error(err))
 	}
-	FileStore = fileStoreFlag
+	log.Info("Config loaded", zap.Any("config", cfg))
+	return cfg
+}
 
-	// Restore metrics from storage
-	restoreEnv, exists := os.LookupEnv("RESTORE")
-	if exists {
-		restoreFlag, err = strconv.ParseBool(restoreEnv)
-		if err != nil {
-			log.Fatal("Error parsing RESTORE", zap.Error(err))
+// saveLoop сохраняет метрики в файл раз в interval, 0 - не сохранять.
+// Новый интервал приходит из updates.
+func saveLoop(s *storage.MemStorage, file string, interval time.Duration, updates <-chan time.Duration) {
+	if file == "" {
+		return
+	}
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	reset := func(d time.Duration) {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if d > 0 {
+			ticker = time.NewTicker(d)
+			tick = ticker.C
 		}
 	}
-	Restore = restoreFlag
+	reset(interval)
 
-	msg := fmt.Sprintf("Server address: %s\nSave interval: %d\nFile store: %s\nRestore: %t", serverAddrFlag, saveIntervalFlag, fileStoreFlag, restoreFlag)
-	log.Info(msg)
+	for {
+		select {
+		case d := <-updates:
+			reset(d)
+			log.Info("Save interval changed", zap.Duration("interval", d))
+		case <-tick:
+			if _, err := s.Save(context.Background(), file); err != nil {
+				log.Fatal("Error saving metrics", zap.Error(err))
+			}
+			log.Debug("Metrics saved")
+		}
+	}
+}
+
+// selfMetricsLoop раз в interval пишет метрики сервера в его же хранилище
+// под selfmetrics.Prefix.
+func selfMetricsLoop(s *storage.MemStorage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for name, v := range selfmetrics.Default.Values() {
+			s.UpdateGauge(selfmetrics.Prefix+name, storage.Gauge(v))
+		}
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	defer log.Sync()
 
 	s := storage.NewMemStorage()
-	ParseFlags()
+	cfg := loadConfig()
+
+	shutdownTracing, err := tracing.Setup("metrics-server", cfg.TraceExporter, cfg.TraceEndpoint)
+	if err != nil {
+		log.Fatal("Error configuring tracing", zap.Error(err))
+	}
+	defer shutdownTracing(context.Background())
 
-	if Restore {
-		if err := s.FromFile(FileStore); err != nil {
+	health := handlers.NewHealth(storePing(cfg.StoreFile))
+
+	if cfg.Restore {
+		_, span := tracing.Start(context.Background(), "storage.FromFile")
+		err := s.FromFile(cfg.StoreFile)
+		tracing.End(span, err)
+		if err != nil {
 			log.Fatal("Error restore metrics", zap.Error(err))
 		}
 		log.Info("Metrics restored")
 	}
+	health.SetRestored()
+
+	saveInterval := make(chan time.Duration, 1)
+	go saveLoop(s, cfg.StoreFile, cfg.StoreInterval.Std(), saveInterval)
+	if cfg.SelfMetricsInterval > 0 {
+		go selfMetricsLoop(s, cfg.SelfMetricsInterval.Std())
+	}
+
+	trusted, err := subnet.Parse(cfg.TrustedSubnet)
+	if err != nil {
+		log.Fatal("Error parsing trusted subnet", zap.Error(err))
+	}
+
+	var tokens *auth.Store
+	if cfg.AuthTokens != "" {
+		tokens, err = auth.LoadFile(cfg.AuthTokens)
+		if err != nil {
+			log.Fatal("Error loading API tokens", zap.Error(err))
+		}
+	}
+
+	rateKey, err := limit.ParseKey(cfg.RateLimitKey)
+	if err != nil {
+		log.Fatal("Error parsing rate limit key", zap.Error(err))
+	}
+	limiter := limit.NewLimiter(cfg.RateLimit, cfg.RateBurst, rateKey)
+
+	var tlsConfig *tls.Config
+	if cfg.TLSCert != "" {
+		reloader, err := certs.NewReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			log.Fatal("Error loading TLS certificate", zap.Error(err))
+		}
+		go reloader.Watch(context.Background(), certReloadInterval)
+
+		tlsConfig, err = certs.ServerConfig(reloader, cfg.TLSClientCA)
+		if err != nil {
+			log.Fatal("Error configuring TLS", zap.Error(err))
+		}
+	}
+
+	var grpcSrv *grpc.Server
+	if cfg.GRPCAddress != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			log.Fatal("Error listen gRPC", zap.Error(err))
+		}
+
+		scopes := map[string]auth.Scope{pb.Metrics_UpdateMetrics_FullMethodName: auth.ScopeWrite}
+		grpcOpts := []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(
+				auth.UnaryInterceptor(tokens, scopes),
+				limit.UnaryInterceptor(limiter),
+				subnet.UnaryInterceptor(trusted, pb.Metrics_UpdateMetrics_FullMethodName),
+			),
+			grpc.ChainStreamInterceptor(auth.StreamInterceptor(tokens, scopes)),
+		}
+		if tlsConfig != nil {
+			grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+
+		grpcSrv = rpc.NewServer(s, stream.GetHub(), grpcOpts...)
+		go func() {
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Fatal("gRPC server error", zap.Error(err))
+			}
+		}()
+	}
+
+	r := &reloader{cfg: cfg, tokens: tokens, limiter: limiter, saveInterval: saveInterval}
+	go r.watchSIGHUP()
+
+	opts := []routers.Option{
+		routers.WithHealth(health),
+		routers.WithStoreFile(cfg.StoreFile),
+		routers.WithReload(r.Reload),
+		routers.WithTrustedSubnet(trusted),
+		routers.WithAuth(tokens),
+		routers.WithRateLimit(limiter),
+		routers.WithBodyLimits(cfg.MaxBodySize, cfg.MaxDecodedBodySize),
+		routers.WithMaxRatio(cfg.MaxGzipRatio),
+	}
+	if cfg.Pprof {
+		opts = append(opts, routers.WithPprof())
+	}
+	if cfg.CryptoKey != "" {
+		key, err := crypto.LoadPrivateKey(cfg.CryptoKey)
+		if err != nil {
+			log.Fatal("Error loading crypto key", zap.Error(err))
+		}
+		opts = append(opts, routers.WithPrivateKey(key))
+	}
 
+	srv := &http.Server{
+		Addr:      cfg.Address,
+		Handler:   routers.InitRouter(opts...),
+		TLSConfig: tlsConfig,
+	}
+
+	serveErr := make(chan error, 1)
 	go func() {
-		if FileStore == "" || SaveInterval <= 0 {
+		if tlsConfig != nil {
+			// Сертификат берётся из TLSConfig.GetCertificate
+			serveErr <- srv.ListenAndServeTLS("", "")
 			return
 		}
+		serveErr <- srv.ListenAndServe()
+	}()
 
-		ticker := time.NewTicker(SaveInterval)
-		for range ticker.C {
-			if err := s.ToFile(FileStore); err != nil {
-				log.Fatal("Error saving metrics", zap.Error(err))
-			}
-			log.Debug("Metrics saved")
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
+		log.Fatal("Server error", zap.Error(err))
+	case sig := <-stop:
+		log.Info("Shutting down", zap.String("signal", sig.String()))
+	}
+
+	// Пока идёт задержка, /readyz уже отвечает 503 и балансировщик
+	// успевает убрать сервер
+	health.ShuttingDown()
+	time.Sleep(cfg.ShutdownDelay.Std())
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Std())
+	defer cancel()
+	shutdown(ctx, srv, grpcSrv)
+
+	if cfg.StoreFile != "" {
+		if _, err := s.Save(context.Background(), cfg.StoreFile); err != nil {
+			log.Error("Error saving metrics", zap.Error(err))
+		} else {
+			log.Info("Metrics saved")
 		}
+	}
+}
+
+// shutdown дожидается завершения текущих запросов, пока не истёк ctx,
+// затем закрывает оставшиеся соединения.
+func shutdown(ctx context.Context, srv *http.Server, grpcSrv *grpc.Server) {
+	// /stream, /ws и StreamUpdates сами не завершаются: Shutdown не
+	// отменяет контекст запроса, а перехваченные /ws не отслеживает
+	stream.GetHub().Shutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warn("HTTP server shutdown", zap.Error(err))
+		srv.Close()
+	}
+
+	if grpcSrv == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(done)
 	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("gRPC server shutdown", zap.Error(ctx.Err()))
+		grpcSrv.Stop()
+	}
+}
+
+// storePing проверяет, что каталог файла хранилища доступен. Без файла
+// хранилище только в памяти и проверять нечего.
+func storePing(file string) func(context.Context) error {
+	if file == "" {
+		return nil
+	}
 
-	r := routers.InitRouter()
-	log.Fatal("Server error", zap.Error(http.ListenAndServe(ServerAddr, r)))
+	return func(context.Context) error {
+		dir := filepath.Dir(file)
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
 }
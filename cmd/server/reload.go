@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+	"github.com/pavelborisofff/go-metrics/internal/config"
+	"github.com/pavelborisofff/go-metrics/internal/limit"
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+)
+
+// reloader перечитывает конфигурацию и применяет изменения, которые
+// не требуют перезапуска.
+type reloader struct {
+	mu      sync.Mutex
+	cfg     *config.Server
+	tokens  *auth.Store
+	limiter *limit.Limiter
+	// saveInterval получает новый интервал сохранения на диск
+	saveInterval chan<- time.Duration
+}
+
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.LoadServer(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	changed := config.RestartRequired(r.cfg, next)
+	// Включить или выключить аутентификацию и лимиты можно только перезапуском:
+	// от этого зависит набор middleware
+	if (r.cfg.AuthTokens == "") != (next.AuthTokens == "") {
+		changed = append(changed, "auth_tokens")
+	}
+	if (r.cfg.RateLimit > 0) != (next.RateLimit > 0) {
+		changed = append(changed, "rate_limit")
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("%w: %s", config.ErrRestartRequired, strings.Join(changed, ", "))
+	}
+
+	// Всё, что может не получиться, до применения
+	var tokens *auth.Store
+	if next.AuthTokens != "" {
+		if tokens, err = auth.LoadFile(next.AuthTokens); err != nil {
+			return err
+		}
+	}
+	rateKey, err := limit.ParseKey(next.RateLimitKey)
+	if err != nil {
+		return err
+	}
+
+	if err = logger.Configure(next.LogOptions()); err != nil {
+		return err
+	}
+	if tokens != nil {
+		r.tokens.Replace(tokens)
+	}
+	if r.limiter != nil {
+		r.limiter.SetLimit(next.RateLimit, next.RateBurst, rateKey)
+	}
+	// Без файла цикл сохранения не запущен, а путь меняется только перезапуском
+	if next.StoreFile != "" && next.StoreInterval != r.cfg.StoreInterval {
+		r.saveInterval <- next.StoreInterval.Std()
+	}
+
+	r.cfg = next
+	log.Info("Config reloaded", zap.Any("config", next))
+	return nil
+}
+
+// watchSIGHUP перечитывает конфигурацию по SIGHUP.
+func (r *reloader) watchSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		if err := r.Reload(); err != nil {
+			log.Error("Config reload rejected", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	// ScopeAdmin включает read и write
+	ScopeAdmin Scope = "admin"
+
+	// QueryParam - токен в URL для браузера, который не может выставить
+	// заголовок при открытии страницы и WebSocket.
+	QueryParam = "access_token"
+)
+
+// Token - запись из файла токенов.
+type Token struct {
+	Name   string  `json:"name"`
+	Token  string  `json:"token"`
+	Scopes []Scope `json:"scopes"`
+	// Prefixes ограничивает имена метрик, которые токен может изменять.
+	// Пустой список - без ограничений.
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+func (t *Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed проверяет, может ли токен изменять метрику name.
+func (t *Token) Allowed(name string) bool {
+	if len(t.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range t.Prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedPattern проверяет glob-шаблон: все подходящие под него имена
+// начинаются с его буквальной части, её и сверяем с префиксами.
+func (t *Token) AllowedPattern(pattern string) bool {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		pattern = pattern[:i]
+	}
+	return t.Allowed(pattern)
+}
+
+// Store хранит токены по sha256, чтобы поиск не зависел от содержимого токена.
+type Store struct {
+	mu     sync.RWMutex
+	tokens map[[sha256.Size]byte]*Token
+}
+
+func NewStore(tokens []Token) (*Store, error) {
+	s := &Store{tokens: make(map[[sha256.Size]byte]*Token, len(tokens))}
+
+	for i := range tokens {
+		t := tokens[i]
+		if t.Name == "" || t.Token == "" {
+			return nil, fmt.Errorf("token #%d: name and token are required", i+1)
+		}
+		for _, scope := range t.Scopes {
+			switch scope {
+			case ScopeRead, ScopeWrite, ScopeAdmin:
+			default:
+				return nil, fmt.Errorf("token %q: unknown scope %q", t.Name, scope)
+			}
+		}
+
+		key := sha256.Sum256([]byte(t.Token))
+		if _, ok := s.tokens[key]; ok {
+			return nil, fmt.Errorf("token %q: duplicate token", t.Name)
+		}
+		s.tokens[key] = &t
+	}
+
+	return s, nil
+}
+
+// LoadFile читает токены из JSON-файла со списком Token.
+func LoadFile(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read tokens: %w", err)
+	}
+
+	var tokens []Token
+	if err = json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parse tokens %s: %w", path, err)
+	}
+
+	return NewStore(tokens)
+}
+
+func (s *Store) Lookup(token string) (*Token, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tokens[sha256.Sum256([]byte(token))]
+	return t, ok
+}
+
+// Replace заменяет токены содержимым other, например перечитанного файла.
+func (s *Store) Replace(other *Store) {
+	other.mu.RLock()
+	tokens := other.tokens
+	other.mu.RUnlock()
+
+	s.mu.Lock()
+	s.tokens = tokens
+	s.mu.Unlock()
+}
+
+type ctxKey struct{}
+
+// FromContext возвращает токен запроса, nil - если аутентификация выключена.
+func FromContext(ctx context.Context) *Token {
+	t, _ := ctx.Value(ctxKey{}).(*Token)
+	return t
+}
+
+func NewContext(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// Allowed - проверка имени метрики для токена из контекста.
+func Allowed(ctx context.Context, name string) bool {
+	t := FromContext(ctx)
+	return t == nil || t.Allowed(name)
+}
+
+func AllowedPattern(ctx context.Context, pattern string) bool {
+	t := FromContext(ctx)
+	return t == nil || t.AllowedPattern(pattern)
+}
+
+// bearer достаёт токен из Authorization, а при query - ещё и из
+// QueryParam.
+func bearer(r *http.Request, query bool) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+			return strings.TrimSpace(h[7:])
+		}
+		return ""
+	}
+	if !query {
+		return ""
+	}
+	return r.URL.Query().Get(QueryParam)
+}
+
+// Require пропускает только запросы с токеном, у которого есть scope.
+// Токен принимается только из заголовка Authorization.
+// Без Store (аутентификация не настроена) пропускает всё.
+func Require(s *Store, scope Scope) func(http.Handler) http.Handler {
+	return requireToken(s, scope, false)
+}
+
+// RequireQuery - Require, который принимает и токен из QueryParam. Только
+// для страниц браузера, где заголовок выставить нельзя: токен в URL
+// попадает в историю браузера и журналы прокси.
+func RequireQuery(s *Store, scope Scope) func(http.Handler) http.Handler {
+	return requireToken(s, scope, true)
+}
+
+func requireToken(s *Store, scope Scope, query bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if s == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, ok := s.Lookup(bearer(r, query))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !t.HasScope(scope) {
+				http.Error(w, fmt.Sprintf("Forbidden: %s scope required", scope), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func testStore(t *testing.T) *Store {
+	s, err := NewStore([]Token{
+		{Name: "dashboard", Token: "r", Scopes: []Scope{ScopeRead}},
+		{Name: "agent", Token: "w", Scopes: []Scope{ScopeWrite}, Prefixes: []string{"agent."}},
+		{Name: "ops", Token: "a", Scopes: []Scope{ScopeAdmin}},
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func TestNewStore(t *testing.T) {
+	_, err := NewStore([]Token{{Name: "x", Token: "t", Scopes: []Scope{"root"}}})
+	assert.Error(t, err)
+
+	_, err = NewStore([]Token{{Name: "x", Token: "t"}, {Name: "y", Token: "t"}})
+	assert.Error(t, err)
+
+	_, err = NewStore([]Token{{Token: "t"}})
+	assert.Error(t, err)
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name":"agent","token":"secret","scopes":["write"]}]`), 0600))
+
+	s, err := LoadFile(path)
+	require.NoError(t, err)
+
+	tok, ok := s.Lookup("secret")
+	require.True(t, ok)
+	assert.Equal(t, "agent", tok.Name)
+
+	_, ok = s.Lookup("other")
+	assert.False(t, ok)
+}
+
+func TestToken(t *testing.T) {
+	s := testStore(t)
+	agent, _ := s.Lookup("w")
+	ops, _ := s.Lookup("a")
+
+	assert.True(t, agent.HasScope(ScopeWrite))
+	assert.False(t, agent.HasScope(ScopeRead))
+	assert.True(t, ops.HasScope(ScopeRead))
+
+	assert.True(t, agent.Allowed("agent.cpu"))
+	assert.False(t, agent.Allowed("server.cpu"))
+	assert.True(t, agent.AllowedPattern("agent.*"))
+	assert.False(t, agent.AllowedPattern("*"))
+	assert.True(t, ops.AllowedPattern("*"))
+}
+
+func TestRequire(t *testing.T) {
+	s := testStore(t)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotNil(t, FromContext(r.Context()))
+	})
+
+	type testType struct {
+		name         string
+		scope        Scope
+		query        bool
+		header       string
+		url          string
+		expectedCode int
+	}
+
+	tests := []testType{
+		{name: "No token", scope: ScopeRead, url: "/", expectedCode: http.StatusUnauthorized},
+		{name: "Unknown token", scope: ScopeRead, header: "Bearer x", url: "/", expectedCode: http.StatusUnauthorized},
+		{name: "Read token", scope: ScopeRead, header: "Bearer r", url: "/", expectedCode: http.StatusOK},
+		{name: "Read token writes", scope: ScopeWrite, header: "Bearer r", url: "/", expectedCode: http.StatusForbidden},
+		{name: "Admin token writes", scope: ScopeWrite, header: "Bearer a", url: "/", expectedCode: http.StatusOK},
+		{name: "Query token", scope: ScopeRead, query: true, url: "/?access_token=r", expectedCode: http.StatusOK},
+		{name: "Query token without RequireQuery", scope: ScopeAdmin, url: "/?access_token=a", expectedCode: http.StatusUnauthorized},
+		{name: "Basic auth", scope: ScopeRead, query: true, header: "Basic r", url: "/?access_token=r", expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.url, nil)
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			res := httptest.NewRecorder()
+
+			mw := Require(s, test.scope)
+			if test.query {
+				mw = RequireQuery(s, test.scope)
+			}
+			mw(ok).ServeHTTP(res, req)
+			assert.Equal(t, test.expectedCode, res.Code)
+		})
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		Require(nil, ScopeAdmin)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).
+			ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	s := testStore(t)
+	interceptor := UnaryInterceptor(s, map[string]Scope{"/metrics/Update": ScopeWrite})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return FromContext(ctx).Name, nil
+	}
+
+	type testType struct {
+		name     string
+		method   string
+		token    string
+		expected codes.Code
+	}
+
+	tests := []testType{
+		{name: "No token", method: "/metrics/Get", expected: codes.Unauthenticated},
+		{name: "Read", method: "/metrics/Get", token: "r", expected: codes.OK},
+		{name: "Read writes", method: "/metrics/Update", token: "r", expected: codes.PermissionDenied},
+		{name: "Write", method: "/metrics/Update", token: "w", expected: codes.OK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, "Bearer "+test.token))
+			}
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: test.method}, handler)
+			assert.Equal(t, test.expected, status.Code(err))
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	s, err := NewStore([]Token{{Name: "old", Token: "old"}})
+	require.NoError(t, err)
+	next, err := NewStore([]Token{{Name: "new", Token: "new"}})
+	require.NoError(t, err)
+
+	s.Replace(next)
+
+	_, ok := s.Lookup("old")
+	assert.False(t, ok)
+	tok, ok := s.Lookup("new")
+	require.True(t, ok)
+	assert.Equal(t, "new", tok.Name)
+}
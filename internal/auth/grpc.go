@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey - bearer-токен в метаданных gRPC.
+const MetadataKey = "authorization"
+
+func fromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	v := md.Get(MetadataKey)
+	if len(v) == 0 || len(v[0]) <= 7 || !strings.EqualFold(v[0][:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(v[0][7:])
+}
+
+func authorize(ctx context.Context, s *Store, scope Scope) (context.Context, error) {
+	t, ok := s.Lookup(fromMetadata(ctx))
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	if !t.HasScope(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s scope required", scope)
+	}
+	return NewContext(ctx, t), nil
+}
+
+// UnaryInterceptor требует токен с указанным для метода scope.
+// Методы вне scopes требуют read. Без Store пропускает всё.
+func UnaryInterceptor(s *Store, scopes map[string]Scope) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if s == nil {
+			return handler(ctx, req)
+		}
+
+		scope, ok := scopes[info.FullMethod]
+		if !ok {
+			scope = ScopeRead
+		}
+
+		ctx, err := authorize(ctx, s, scope)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authStream) Context() context.Context {
+	return a.ctx
+}
+
+// StreamInterceptor - то же для потоковых методов.
+func StreamInterceptor(s *Store, scopes map[string]Scope) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if s == nil {
+			return handler(srv, ss)
+		}
+
+		scope, ok := scopes[info.FullMethod]
+		if !ok {
+			scope = ScopeRead
+		}
+
+		ctx, err := authorize(ss.Context(), s, scope)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// WithToken передаёт токен в метаданных каждого вызова клиента.
+// token вызывается на каждый вызов, так токен можно сменить на ходу.
+func WithToken(token func() string) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if t := token(); t != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, MetadataKey, "Bearer "+t)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
@@ -0,0 +1,167 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	path string
+}
+
+var serial int64
+
+func newCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+
+	return &testCA{cert: cert, key: key, path: path}
+}
+
+// issue выпускает сертификат и пишет его в dir/name.pem и dir/name-key.pem.
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(dir, name+".pem")
+	keyPath := filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certPath, keyPath
+}
+
+// startServer не использует httptest: StartTLS подставляет свой сертификат
+// в Certificates, и GetCertificate не вызывается.
+func startServer(t *testing.T, r *Reloader, clientCA string) string {
+	cfg, err := ServerConfig(r, clientCA)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &http.Server{
+		Handler:  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ErrorLog: stdlog.New(io.Discard, "", 0),
+	}
+	go srv.Serve(tls.NewListener(lis, cfg))
+	t.Cleanup(func() { srv.Close() })
+
+	return "https://" + lis.Addr().String()
+}
+
+func get(t *testing.T, url, caFile, certFile, keyFile string) (*http.Response, error) {
+	cfg, err := ClientConfig(caFile, certFile, keyFile)
+	require.NoError(t, err)
+
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	res, err := c.Get(url)
+	if err == nil {
+		res.Body.Close()
+	}
+	return res, err
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+
+	r, err := NewReloader(serverCert, serverKey)
+	require.NoError(t, err)
+	url := startServer(t, r, ca.path)
+
+	res, err := get(t, url, ca.path, clientCert, clientKey)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	_, err = get(t, url, ca.path, "", "")
+	assert.Error(t, err, "client without certificate must be rejected")
+
+	_, err = get(t, url, "", clientCert, clientKey)
+	assert.Error(t, err, "server certificate must not be trusted without the CA")
+}
+
+func TestReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := newCA(t, dir)
+	certFile, keyFile := oldCA.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+
+	r, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+	url := startServer(t, r, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	// Новый CA выпускает сертификат поверх старых файлов
+	newDir := t.TempDir()
+	nextCA := newCA(t, newDir)
+	nextCA.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	require.Eventually(t, func() bool {
+		_, err := get(t, url, nextCA.path, "", "")
+		return err == nil
+	}, 2*time.Second, 20*time.Millisecond)
+
+	_, err = get(t, url, oldCA.path, "", "")
+	assert.Error(t, err)
+}
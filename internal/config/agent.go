@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pavelborisofff/go-metrics/internal/gzip"
+)
+
+// Agent - настройки cmd/agent. Поля с тегом reload:"live" применяются
+// при перечитывании конфигурации без перезапуска.
+type Agent struct {
+	common `json:"-" yaml:"-"`
+
+	// Address - адрес сервера, схема необязательна
+	Address        string   `json:"address" yaml:"address"`
+	PollInterval   Duration `json:"poll_interval" yaml:"poll_interval" reload:"live"`
+	ReportInterval Duration `json:"report_interval" yaml:"report_interval" reload:"live"`
+	GRPCAddress    string   `json:"grpc_address" yaml:"grpc_address"`
+	CryptoKey      string   `json:"crypto_key" yaml:"crypto_key"`
+
+	TLSCA   string `json:"tls_ca" yaml:"tls_ca"`
+	TLSCert string `json:"tls_cert" yaml:"tls_cert"`
+	TLSKey  string `json:"tls_key" yaml:"tls_key"`
+
+	Token    string `json:"token" yaml:"token" reload:"live"`
+	Compress string `json:"compress" yaml:"compress" reload:"live"`
+
+	Log   `yaml:",inline" reload:"live"`
+	Trace `yaml:",inline"`
+}
+
+func DefaultAgent() Agent {
+	return Agent{
+		Address:        "localhost:8080",
+		PollInterval:   Duration(2 * time.Second),
+		ReportInterval: Duration(10 * time.Second),
+		Compress:       "gzip",
+		Log:            defaultLog(),
+	}
+}
+
+func (c *Agent) bind(b *binder) {
+	c.common.bind(b)
+
+	b.String(&c.Address, "a", "ADDRESS", "Server address")
+	b.Duration(&c.PollInterval, "p", "POLL_INTERVAL", "Poll interval")
+	b.Duration(&c.ReportInterval, "r", "REPORT_INTERVAL", "Report interval")
+	b.String(&c.GRPCAddress, "g", "GRPC_ADDRESS", "gRPC server address, report via gRPC instead of HTTP if set")
+	b.String(&c.CryptoKey, "crypto-key", "CRYPTO_KEY", "Path to the server's public key (PEM), encrypt HTTP metrics if set")
+	b.String(&c.TLSCA, "tls-ca", "TLS_CA", "CA bundle (PEM) to verify the server, use HTTPS if set")
+	b.String(&c.TLSCert, "tls-cert", "TLS_CERT", "Client certificate (PEM) for mTLS")
+	b.String(&c.TLSKey, "tls-key", "TLS_KEY", "Client private key (PEM) for mTLS")
+	b.String(&c.Token, "token", "API_TOKEN", "API token for the server")
+	b.String(&c.Compress, "compress", "COMPRESS", "Request compression: gzip, deflate, zstd or br")
+	c.Log.bind(b)
+	c.Trace.bind(b)
+}
+
+// LoadAgent читает настройки агента из файла, окружения и args.
+func LoadAgent(args []string) (*Agent, error) {
+	c := DefaultAgent()
+	if err := load("agent", args, c.bind, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Agent) Validate() error {
+	var errs []error
+
+	if c.Address == "" {
+		errs = append(errs, errors.New("address is required"))
+	}
+	if c.PollInterval.Std() < time.Second {
+		errs = append(errs, errors.New("poll_interval must be >= 1s"))
+	}
+	if c.ReportInterval.Std() < time.Second {
+		errs = append(errs, errors.New("report_interval must be >= 1s"))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	// RSA-шифрование есть только у HTTP, gRPC шифруется TLS
+	if c.CryptoKey != "" && c.GRPCAddress != "" {
+		errs = append(errs, errors.New("crypto_key applies to HTTP only and cannot be used with grpc_address, use tls_ca to encrypt gRPC"))
+	}
+	if _, ok := gzip.Lookup(c.Compress); !ok {
+		errs = append(errs, fmt.Errorf("unknown compress codec %q", c.Compress))
+	}
+	errs = append(errs, c.Log.validate()...)
+	errs = append(errs, c.Trace.validate()...)
+
+	return errors.Join(errs...)
+}
+
+// ServerURL - адрес сервера со схемой. Схему можно указать явно,
+// иначе https включается любым TLS-параметром.
+func (c *Agent) ServerURL() string {
+	switch {
+	case strings.Contains(c.Address, "://"):
+		return c.Address
+	case c.TLSCA != "" || c.TLSCert != "":
+		return "https://" + c.Address
+	default:
+		return "http://" + c.Address
+	}
+}
+
+// Redacted - копия для печати без токена.
+func (c Agent) Redacted() Agent {
+	if c.Token != "" {
+		c.Token = "***"
+	}
+	return c
+}
@@ -0,0 +1,320 @@
+// Package config собирает настройки сервера и агента из файла, переменных
+// окружения и флагов.
+//
+// Приоритет, от низшего к высшему:
+//
+//  1. значения по умолчанию;
+//  2. файл конфигурации (-c или CONFIG), JSON или YAML по расширению;
+//  3. переменные окружения;
+//  4. флаги командной строки.
+//
+// Интервалы задаются строками time.ParseDuration ("10s", "5m"), число без
+// единиц для совместимости означает секунды.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration - time.Duration, который читается из строк вида "10s" или "300".
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*d = Duration(time.Duration(sec) * time.Second)
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("bad duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// deferred откладывает разбор значения флага: flag.FlagSet останавливается
+// на первой ошибке, а нужно сообщить обо всех сразу.
+type deferred struct {
+	flag.Value
+	raw string
+	set bool
+}
+
+func (d *deferred) Set(s string) error {
+	d.raw, d.set = s, true
+	return nil
+}
+
+func (d *deferred) IsBoolFlag() bool {
+	b, ok := d.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// field - поле конфигурации с флагом и переменной окружения.
+type field struct {
+	name string
+	env  string
+	flag *deferred
+}
+
+// binder регистрирует поля во FlagSet и запоминает их переменные окружения.
+type binder struct {
+	fs     *flag.FlagSet
+	fields []field
+}
+
+// add регистрирует флаг, имя переменной окружения попадает в справку.
+func (b *binder) add(name, env, usage string, v flag.Value) {
+	d := &deferred{Value: v}
+	if env != "" {
+		usage = fmt.Sprintf("%s (env `%s`)", usage, env)
+	}
+
+	b.fs.Var(d, name, usage)
+	b.fields = append(b.fields, field{name: name, env: env, flag: d})
+}
+
+func (b *binder) String(p *string, name, env, usage string) {
+	b.add(name, env, usage, (*stringValue)(p))
+}
+
+func (b *binder) Bool(p *bool, name, env, usage string) {
+	b.add(name, env, usage, (*boolValue)(p))
+}
+
+func (b *binder) Int(p *int, name, env, usage string) {
+	b.add(name, env, usage, (*intValue)(p))
+}
+
+func (b *binder) Int64(p *int64, name, env, usage string) {
+	b.add(name, env, usage, (*int64Value)(p))
+}
+
+func (b *binder) Float64(p *float64, name, env, usage string) {
+	b.add(name, env, usage, (*float64Value)(p))
+}
+
+func (b *binder) Duration(p *Duration, name, env, usage string) {
+	b.add(name, env, usage, (*durationValue)(p))
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("bad bool %q", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("bad integer %q", s)
+	}
+	*v = intValue(i)
+	return nil
+}
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type int64Value int64
+
+func (v *int64Value) Set(s string) error {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad integer %q", s)
+	}
+	*v = int64Value(i)
+	return nil
+}
+func (v *int64Value) String() string { return strconv.FormatInt(int64(*v), 10) }
+
+type float64Value float64
+
+func (v *float64Value) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("bad number %q", s)
+	}
+	*v = float64Value(f)
+	return nil
+}
+func (v *float64Value) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+
+type durationValue Duration
+
+func (v *durationValue) Set(s string) error { return (*Duration)(v).UnmarshalText([]byte(s)) }
+func (v *durationValue) String() string     { return time.Duration(*v).String() }
+
+// common - поля, которые есть у обеих программ.
+type common struct {
+	// Path - файл конфигурации, сам в файле не задаётся
+	Path string `json:"-" yaml:"-"`
+	// Print - вывести итоговую конфигурацию и выйти
+	Print bool `json:"-" yaml:"-"`
+}
+
+func (c *common) bind(b *binder) {
+	b.String(&c.Path, "c", "CONFIG", "Config file (JSON or YAML)")
+	b.Bool(&c.Print, "print-config", "", "Print the effective config and exit")
+}
+
+// load дополняет cfg со значениями по умолчанию по приоритетам из описания
+// пакета и проверяет его. bind регистрирует поля cfg.
+func load(name string, args []string, bind func(*binder), cfg interface{ Validate() error }) error {
+	b := &binder{fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+	bind(b)
+
+	if err := b.fs.Parse(args); err != nil {
+		return err
+	}
+	if b.fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(b.fs.Args(), " "))
+	}
+
+	// Значения флагов разобраны отложенно и в cfg ещё не попали
+	// Путь к файлу нужен раньше остальных полей
+	var path string
+	for _, f := range b.fields {
+		if f.name != "c" {
+			continue
+		}
+		path = os.Getenv(f.env)
+		if f.flag.set {
+			path = f.flag.raw
+		}
+	}
+	if path != "" {
+		if err := readFile(path, cfg); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, f := range b.fields {
+		if f.env == "" {
+			continue
+		}
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := f.flag.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+			}
+		}
+	}
+
+	for _, f := range b.fields {
+		if !f.flag.set {
+			continue
+		}
+		if err := f.flag.Value.Set(f.flag.raw); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.name, err))
+		}
+	}
+
+	errs = append(errs, cfg.Validate())
+	return errors.Join(errs...)
+}
+
+func readFile(path string, cfg interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(data)))
+		dec.KnownFields(true)
+		err = dec.Decode(cfg)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		dec := json.NewDecoder(strings.NewReader(string(data)))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Print выводит конфигурацию в JSON, так же её можно передать через -c.
+func Print(w io.Writer, cfg interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cfg)
+}
+
+func validLevel(l string) error {
+	if _, err := zapcore.ParseLevel(l); err != nil {
+		return fmt.Errorf("log_level: %w", err)
+	}
+	return nil
+}
+
+// ErrRestartRequired - новая конфигурация меняет то, что применяется
+// только при запуске.
+var ErrRestartRequired = errors.New("restart required")
+
+// RestartRequired возвращает поля без тега reload:"live", которые в next
+// отличаются от cur. Такие изменения нельзя применить без перезапуска.
+// Поля встроенных структур проверяются так же, кроме скрытых json:"-".
+func RestartRequired(cur, next interface{}) []string {
+	a, b := reflect.ValueOf(cur), reflect.ValueOf(next)
+	if a.Kind() == reflect.Pointer {
+		a, b = a.Elem(), b.Elem()
+	}
+
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		f := a.Type().Field(i)
+		if f.Tag.Get("reload") == "live" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		if f.Anonymous {
+			changed = append(changed, RestartRequired(a.Field(i).Interface(), b.Field(i).Interface())...)
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeFile(t, "server.yaml", `
+address: file:8080
+store_interval: 1m
+store_file: /var/lib/metrics.json
+rate_limit: 5
+`)
+
+	t.Setenv("CONFIG", path)
+	t.Setenv("STORE_INTERVAL", "30s")
+	t.Setenv("FILE_STORAGE_PATH", "/env/metrics.json")
+
+	c, err := LoadServer([]string{"-f", "/flag/metrics.json"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "file:8080", c.Address, "file overrides default")
+	assert.Equal(t, 30*time.Second, c.StoreInterval.Std(), "env overrides file")
+	assert.Equal(t, "/flag/metrics.json", c.StoreFile, "flag overrides env")
+	assert.Equal(t, 5.0, c.RateLimit)
+	assert.True(t, c.Restore, "default kept")
+}
+
+func TestConfigFlag(t *testing.T) {
+	path := writeFile(t, "agent.json", `{"address": "json:8080", "poll_interval": "500ms", "report_interval": "3"}`)
+	t.Setenv("CONFIG", "/does/not/exist.json")
+
+	c, err := LoadAgent([]string{"-c", path, "-p", "5s"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "json:8080", c.Address)
+	assert.Equal(t, 5*time.Second, c.PollInterval.Std())
+	assert.Equal(t, 3*time.Second, c.ReportInterval.Std(), "plain number means seconds")
+	assert.Equal(t, "http://json:8080", c.ServerURL())
+}
+
+func TestAllErrors(t *testing.T) {
+	t.Setenv("POLL_INTERVAL", "often")
+
+	_, err := LoadAgent([]string{"-r", "0", "-tls-cert", "cert.pem", "-compress", "lzma"})
+	require.Error(t, err)
+
+	msg := err.Error()
+	for _, want := range []string{"POLL_INTERVAL", "report_interval", "tls_cert and tls_key", "lzma"} {
+		assert.Contains(t, msg, want)
+	}
+	assert.Len(t, strings.Split(msg, "\n"), 4)
+}
+
+func TestCryptoKeyWithGRPC(t *testing.T) {
+	_, err := LoadAgent([]string{"-crypto-key", "public.pem", "-g", "localhost:3200"})
+	assert.ErrorContains(t, err, "crypto_key applies to HTTP only")
+
+	_, err = LoadAgent([]string{"-crypto-key", "public.pem"})
+	assert.NoError(t, err)
+}
+
+func TestPprofRequiresAuth(t *testing.T) {
+	_, err := LoadServer([]string{"-pprof"})
+	assert.ErrorContains(t, err, "pprof requires auth_tokens")
+
+	_, err = LoadServer([]string{"-pprof", "-auth-tokens", "tokens.json"})
+	assert.NoError(t, err)
+}
+
+func TestUnknownField(t *testing.T) {
+	path := writeFile(t, "server.json", `{"adress": "typo:8080"}`)
+
+	_, err := LoadServer([]string{"-c", path})
+	assert.ErrorContains(t, err, "adress")
+}
+
+func TestDuration(t *testing.T) {
+	var d Duration
+	require.NoError(t, d.UnmarshalText([]byte("300")))
+	assert.Equal(t, 5*time.Minute, d.Std())
+
+	require.NoError(t, d.UnmarshalText([]byte("1h30m")))
+	assert.Equal(t, 90*time.Minute, d.Std())
+
+	text, err := d.MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "1h30m0s", string(text))
+
+	assert.Error(t, d.UnmarshalText([]byte("soon")))
+}
+
+func TestRedacted(t *testing.T) {
+	c := DefaultAgent()
+	c.Token = "secret"
+
+	assert.Equal(t, "***", c.Redacted().Token)
+	assert.Equal(t, "secret", c.Token)
+}
+
+func TestRestartRequired(t *testing.T) {
+	cur, next := DefaultServer(), DefaultServer()
+	next.StoreInterval = Duration(time.Minute)
+	next.LogLevel = "info"
+	next.RateBurst = 50
+	assert.Empty(t, RestartRequired(cur, next))
+
+	next.Address = "localhost:9090"
+	next.Restore = false
+	next.TraceExporter = "stdout"
+	assert.Equal(t, []string{"address", "restore", "trace_exporter"}, RestartRequired(cur, next))
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+)
+
+// Log - настройки логирования, общие для сервера и агента. Все применяются
+// без перезапуска.
+type Log struct {
+	LogLevel  string `json:"log_level" yaml:"log_level"`
+	LogFormat string `json:"log_format" yaml:"log_format"`
+	// LogFile - файл лога, пусто - stderr
+	LogFile       string `json:"log_file" yaml:"log_file"`
+	LogMaxSize    int64  `json:"log_max_size" yaml:"log_max_size"`
+	LogMaxBackups int    `json:"log_max_backups" yaml:"log_max_backups"`
+}
+
+func defaultLog() Log {
+	return Log{
+		LogLevel:      "debug",
+		LogFormat:     "console",
+		LogMaxSize:    100 << 20,
+		LogMaxBackups: 3,
+	}
+}
+
+func (c *Log) bind(b *binder) {
+	b.String(&c.LogLevel, "log-level", "LOG_LEVEL", "Log level: debug, info, warn or error")
+	b.String(&c.LogFormat, "log-format", "LOG_FORMAT", "Log format: console or json")
+	b.String(&c.LogFile, "log-file", "LOG_FILE", "Log file (stderr if empty)")
+	b.Int64(&c.LogMaxSize, "log-max-size", "LOG_MAX_SIZE", "Rotate the log file after this many bytes (disabled if 0)")
+	b.Int(&c.LogMaxBackups, "log-max-backups", "LOG_MAX_BACKUPS", "Rotated log files to keep")
+}
+
+func (c *Log) validate() []error {
+	var errs []error
+
+	if err := validLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.LogFormat {
+	case "console", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log_format must be console or json, got %q", c.LogFormat))
+	}
+	if c.LogMaxSize < 0 || c.LogMaxBackups < 0 {
+		errs = append(errs, errors.New("log_max_size and log_max_backups must be >= 0"))
+	}
+
+	return errs
+}
+
+// LogOptions - настройки для logger.Configure.
+func (c *Log) LogOptions() logger.Options {
+	return logger.Options{
+		Level:      c.LogLevel,
+		Format:     c.LogFormat,
+		File:       c.LogFile,
+		MaxSize:    c.LogMaxSize,
+		MaxBackups: c.LogMaxBackups,
+	}
+}
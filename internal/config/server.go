@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+)
+
+// Server - настройки cmd/server. Поля с тегом reload:"live" применяются
+// при перечитывании конфигурации без перезапуска.
+type Server struct {
+	common `json:"-" yaml:"-"`
+
+	Address       string   `json:"address" yaml:"address"`
+	StoreInterval Duration `json:"store_interval" yaml:"store_interval" reload:"live"`
+	StoreFile     string   `json:"store_file" yaml:"store_file"`
+	Restore       bool     `json:"restore" yaml:"restore"`
+	GRPCAddress   string   `json:"grpc_address" yaml:"grpc_address"`
+	CryptoKey     string   `json:"crypto_key" yaml:"crypto_key"`
+	TrustedSubnet string   `json:"trusted_subnet" yaml:"trusted_subnet"`
+
+	TLSCert     string `json:"tls_cert" yaml:"tls_cert"`
+	TLSKey      string `json:"tls_key" yaml:"tls_key"`
+	TLSClientCA string `json:"tls_client_ca" yaml:"tls_client_ca"`
+
+	AuthTokens string `json:"auth_tokens" yaml:"auth_tokens" reload:"live"`
+
+	RateLimit    float64 `json:"rate_limit" yaml:"rate_limit" reload:"live"`
+	RateBurst    int     `json:"rate_burst" yaml:"rate_burst" reload:"live"`
+	RateLimitKey string  `json:"rate_limit_key" yaml:"rate_limit_key" reload:"live"`
+
+	MaxBodySize        int64   `json:"max_body_size" yaml:"max_body_size"`
+	MaxDecodedBodySize int64   `json:"max_decoded_body_size" yaml:"max_decoded_body_size"`
+	MaxGzipRatio       float64 `json:"max_gzip_ratio" yaml:"max_gzip_ratio"`
+
+	// ShutdownDelay - сколько отвечать 503 на /readyz перед остановкой
+	ShutdownDelay Duration `json:"shutdown_delay" yaml:"shutdown_delay"`
+	// ShutdownTimeout - сколько ждать завершения запросов при остановке
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+
+	// Pprof - включить /debug/pprof для токенов admin
+	Pprof bool `json:"pprof" yaml:"pprof"`
+
+	// SelfMetricsInterval - как часто писать метрики сервера в хранилище, 0 - не писать
+	SelfMetricsInterval Duration `json:"self_metrics_interval" yaml:"self_metrics_interval"`
+
+	Log   `yaml:",inline" reload:"live"`
+	Trace `yaml:",inline"`
+	// LogSample - писать в лог каждый n-й успешный запрос
+	LogSample int `json:"log_sample" yaml:"log_sample" reload:"live"`
+}
+
+func DefaultServer() Server {
+	return Server{
+		Address:            "localhost:8080",
+		StoreInterval:      Duration(300 * time.Second),
+		StoreFile:          "/tmp/metrics-db.json",
+		Restore:            true,
+		RateBurst:          20,
+		RateLimitKey:       "ip",
+		MaxBodySize:        1 << 20,
+		MaxDecodedBodySize: 8 << 20,
+		MaxGzipRatio:       100,
+		ShutdownTimeout:    Duration(10 * time.Second),
+		Log:                defaultLog(),
+	}
+}
+
+func (c *Server) bind(b *binder) {
+	c.common.bind(b)
+
+	b.String(&c.Address, "a", "ADDRESS", "HTTP server address")
+	b.Duration(&c.StoreInterval, "i", "STORE_INTERVAL", "Save to file interval, 0 disables periodic saving")
+	b.String(&c.StoreFile, "f", "FILE_STORAGE_PATH", "File to save metrics to")
+	b.Bool(&c.Restore, "r", "RESTORE", "Restore metrics from the file on start")
+	b.String(&c.GRPCAddress, "g", "GRPC_ADDRESS", "gRPC server address (disabled if empty)")
+	b.String(&c.CryptoKey, "crypto-key", "CRYPTO_KEY", "Path to the server's private key (PEM) to decrypt agent payloads")
+	b.String(&c.TrustedSubnet, "t", "TRUSTED_SUBNET", "Trusted subnet (CIDR) allowed to write metrics")
+	b.String(&c.TLSCert, "tls-cert", "TLS_CERT", "TLS certificate (PEM), serve HTTPS if set")
+	b.String(&c.TLSKey, "tls-key", "TLS_KEY", "TLS private key (PEM)")
+	b.String(&c.TLSClientCA, "tls-client-ca", "TLS_CLIENT_CA", "CA bundle (PEM) to verify client certificates (mTLS)")
+	b.String(&c.AuthTokens, "auth-tokens", "AUTH_TOKENS_FILE", "API tokens file (JSON), require bearer auth if set")
+	b.Float64(&c.RateLimit, "rate-limit", "RATE_LIMIT", "Requests per second per client (disabled if 0)")
+	b.Int(&c.RateBurst, "rate-burst", "RATE_BURST", "Rate limit burst size")
+	b.String(&c.RateLimitKey, "rate-key", "RATE_LIMIT_KEY", "Rate limit client key: ip, token or agent")
+	b.Int64(&c.MaxBodySize, "max-body", "MAX_BODY_SIZE", "Max request body size in bytes (disabled if 0)")
+	b.Int64(&c.MaxDecodedBodySize, "max-decoded-body", "MAX_DECODED_BODY_SIZE", "Max request body size after decompression in bytes (disabled if 0)")
+	b.Float64(&c.MaxGzipRatio, "max-gzip-ratio", "MAX_GZIP_RATIO", "Max compression ratio of request bodies (disabled if 0)")
+	b.Duration(&c.ShutdownDelay, "shutdown-delay", "SHUTDOWN_DELAY", "Keep serving with /readyz failing this long before shutdown")
+	b.Duration(&c.ShutdownTimeout, "shutdown-timeout", "SHUTDOWN_TIMEOUT", "Wait this long for in-flight requests on shutdown")
+	b.Bool(&c.Pprof, "pprof", "PPROF", "Serve /debug/pprof to admin tokens, requires -auth-tokens")
+	b.Duration(&c.SelfMetricsInterval, "self-metrics-interval", "SELF_METRICS_INTERVAL", "Write server metrics into storage under _self. this often (disabled if 0)")
+	c.Log.bind(b)
+	c.Trace.bind(b)
+	b.Int(&c.LogSample, "log-sample", "LOG_SAMPLE", "Log every n-th successful request (all if 0 or 1)")
+}
+
+// LogOptions - настройки логгера вместе с выборкой запросов.
+func (c *Server) LogOptions() logger.Options {
+	o := c.Log.LogOptions()
+	o.Sample = c.LogSample
+	return o
+}
+
+// LoadServer читает настройки сервера из файла, окружения и args.
+func LoadServer(args []string) (*Server, error) {
+	c := DefaultServer()
+	if err := load("server", args, c.bind, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate проверяет все поля и возвращает все найденные ошибки сразу.
+func (c *Server) Validate() error {
+	var errs []error
+
+	if c.Address == "" {
+		errs = append(errs, errors.New("address is required"))
+	}
+	if c.StoreInterval < 0 {
+		errs = append(errs, errors.New("store_interval must be >= 0"))
+	}
+	if c.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.TrustedSubnet); err != nil {
+			errs = append(errs, fmt.Errorf("trusted_subnet: %w", err))
+		}
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		errs = append(errs, errors.New("tls_client_ca requires tls_cert and tls_key"))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, errors.New("rate_limit must be >= 0"))
+	}
+	if c.RateLimit > 0 && c.RateBurst < 1 {
+		errs = append(errs, errors.New("rate_burst must be >= 1"))
+	}
+	switch c.RateLimitKey {
+	case "ip", "token", "agent":
+	default:
+		errs = append(errs, fmt.Errorf("rate_limit_key must be ip, token or agent, got %q", c.RateLimitKey))
+	}
+	if c.MaxBodySize < 0 || c.MaxDecodedBodySize < 0 {
+		errs = append(errs, errors.New("body size limits must be >= 0"))
+	}
+	if c.MaxGzipRatio < 0 {
+		errs = append(errs, errors.New("max_gzip_ratio must be >= 0"))
+	}
+	if c.ShutdownDelay < 0 || c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("shutdown_delay and shutdown_timeout must be >= 0"))
+	}
+	if c.Pprof && c.AuthTokens == "" {
+		errs = append(errs, errors.New("pprof requires auth_tokens"))
+	}
+	if c.SelfMetricsInterval < 0 {
+		errs = append(errs, errors.New("self_metrics_interval must be >= 0"))
+	}
+	errs = append(errs, c.Log.validate()...)
+	errs = append(errs, c.Trace.validate()...)
+	if c.LogSample < 0 {
+		errs = append(errs, errors.New("log_sample must be >= 0"))
+	}
+
+	return errors.Join(errs...)
+}
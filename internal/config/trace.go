@@ -0,0 +1,36 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
+)
+
+// Trace - настройки экспорта спанов OpenTelemetry, общие для сервера и агента.
+type Trace struct {
+	TraceExporter string `json:"trace_exporter" yaml:"trace_exporter"`
+	// TraceEndpoint - адрес OTLP-коллектора (host:port)
+	TraceEndpoint string `json:"trace_endpoint" yaml:"trace_endpoint"`
+}
+
+func (c *Trace) bind(b *binder) {
+	b.String(&c.TraceExporter, "trace-exporter", "TRACE_EXPORTER", "Trace exporter: none, stdout or otlp")
+	b.String(&c.TraceEndpoint, "trace-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTLP gRPC collector address (host:port)")
+}
+
+func (c *Trace) validate() []error {
+	var errs []error
+
+	switch c.TraceExporter {
+	case "", tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if c.TraceEndpoint == "" {
+			errs = append(errs, errors.New("trace_endpoint is required for the otlp exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("trace_exporter must be none, stdout or otlp, got %q", c.TraceExporter))
+	}
+
+	return errs
+}
@@ -0,0 +1,260 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// Header помечает зашифрованное тело запроса.
+const Header = "X-Encrypted"
+
+const (
+	modeRSA    byte = 1 // тело целиком зашифровано RSA-OAEP
+	modeHybrid byte = 2 // RSA-OAEP шифрует AES-256 ключ, тело - AES-GCM
+
+	sessionKeySize = 32
+)
+
+var (
+	ErrKeyMismatch = errors.New("crypto: can't decrypt body, the client's public key doesn't match the server's private key")
+	ErrMalformed   = errors.New("crypto: malformed encrypted body")
+)
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("crypto: read key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("crypto: %s: no PEM data found", path)
+	}
+
+	return block, nil
+}
+
+// LoadPublicKey читает RSA-ключ из PEM-файла: PKIX ("PUBLIC KEY"),
+// PKCS#1 ("RSA PUBLIC KEY") или сертификат.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+		return nil, fmt.Errorf("crypto: %s: got private key, the agent needs the server's public key", path)
+	default:
+		return nil, fmt.Errorf("crypto: %s: unexpected PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("crypto: %s: %w", path, err)
+	}
+
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("crypto: %s: not an RSA public key", path)
+	}
+
+	return pub, nil
+}
+
+// LoadPrivateKey читает RSA-ключ из PEM-файла: PKCS#8 ("PRIVATE KEY")
+// или PKCS#1 ("RSA PRIVATE KEY").
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY", "RSA PUBLIC KEY", "CERTIFICATE":
+		return nil, fmt.Errorf("crypto: %s: got public key, the server needs its private key", path)
+	default:
+		return nil, fmt.Errorf("crypto: %s: unexpected PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("crypto: %s: %w", path, err)
+	}
+
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("crypto: %s: not an RSA private key", path)
+	}
+
+	return priv, nil
+}
+
+func maxRSASize(pub *rsa.PublicKey) int {
+	return pub.Size() - 2*sha256.Size - 2
+}
+
+// Encrypt шифрует data открытым ключом. Небольшие данные шифруются RSA-OAEP
+// напрямую, большие - AES-GCM на случайном сессионном ключе, который
+// шифруется RSA-OAEP.
+func Encrypt(pub *rsa.PublicKey, data []byte) ([]byte, error) {
+	if len(data) <= maxRSASize(pub) {
+		encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{modeRSA}, encrypted...), nil
+	}
+
+	sessionKey := make([]byte, sessionKeySize)
+	if _, err := io.ReadFull(rand.Reader, sessionKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, sessionKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	// mode | длина ключа (2 байта) | ключ | nonce | шифротекст
+	var buf bytes.Buffer
+	buf.Grow(1 + 2 + len(wrappedKey) + len(nonce) + len(data) + gcm.Overhead())
+	buf.WriteByte(modeHybrid)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(wrappedKey)))
+	buf.Write(wrappedKey)
+	buf.Write(nonce)
+	buf.Write(gcm.Seal(nil, nonce, data, nil))
+
+	return buf.Bytes(), nil
+}
+
+func Decrypt(priv *rsa.PrivateKey, data []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, ErrMalformed
+	}
+
+	switch data[0] {
+	case modeRSA:
+		plain, err := rsa.DecryptOAEP(sha256.New(), nil, priv, data[1:], nil)
+		if err != nil {
+			return nil, ErrKeyMismatch
+		}
+		return plain, nil
+
+	case modeHybrid:
+		if len(data) < 3 {
+			return nil, ErrMalformed
+		}
+		keyLen := int(binary.BigEndian.Uint16(data[1:3]))
+		data = data[3:]
+		if len(data) < keyLen {
+			return nil, ErrMalformed
+		}
+
+		sessionKey, err := rsa.DecryptOAEP(sha256.New(), nil, priv, data[:keyLen], nil)
+		if err != nil {
+			return nil, ErrKeyMismatch
+		}
+		data = data[keyLen:]
+
+		gcm, err := newGCM(sessionKey)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		if len(data) < gcm.NonceSize() {
+			return nil, ErrMalformed
+		}
+
+		plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		return plain, nil
+
+	default:
+		return nil, ErrMalformed
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// DecryptHandle расшифровывает тело запросов с заголовком Header.
+// Должен стоять до gzip.GzipHandle: агент сначала сжимает, потом шифрует.
+func DecryptHandle(priv *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(Header) == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if priv == nil {
+				http.Error(w, "Encryption is not configured on the server", http.StatusBadRequest)
+				return
+			}
+
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "Error read body", http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
+
+			plain, err := Decrypt(priv, data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			r.Header.Del(Header)
+			r.Body = io.NopCloser(bytes.NewReader(plain))
+			r.ContentLength = int64(len(plain))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
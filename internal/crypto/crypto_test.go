@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeys(t *testing.T) (string, string, *rsa.PrivateKey) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privPath := filepath.Join(dir, "private.pem")
+	pubPath := filepath.Join(dir, "public.pem")
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600))
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644))
+
+	return privPath, pubPath, priv
+}
+
+func TestLoadKeys(t *testing.T) {
+	privPath, pubPath, priv := writeKeys(t)
+
+	pub, err := LoadPublicKey(pubPath)
+	require.NoError(t, err)
+	assert.True(t, priv.PublicKey.Equal(pub))
+
+	loaded, err := LoadPrivateKey(privPath)
+	require.NoError(t, err)
+	assert.True(t, priv.Equal(loaded))
+
+	_, err = LoadPublicKey(privPath)
+	assert.ErrorContains(t, err, "got private key")
+
+	_, err = LoadPrivateKey(pubPath)
+	assert.ErrorContains(t, err, "got public key")
+
+	_, err = LoadPublicKey(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	_, _, priv := writeKeys(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	type testType struct {
+		name string
+		data []byte
+		mode byte
+	}
+
+	tests := []testType{
+		{name: "Small payload", data: []byte(`{"id":"Alloc","type":"gauge","value":1}`), mode: modeRSA},
+		{name: "Large payload", data: bytes.Repeat([]byte("metrics"), 1000), mode: modeHybrid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encrypted, err := Encrypt(&priv.PublicKey, test.data)
+			require.NoError(t, err)
+			assert.Equal(t, test.mode, encrypted[0])
+
+			plain, err := Decrypt(priv, encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, test.data, plain)
+
+			_, err = Decrypt(other, encrypted)
+			assert.ErrorIs(t, err, ErrKeyMismatch)
+
+			_, err = Decrypt(priv, encrypted[:len(encrypted)/2])
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDecryptHandle(t *testing.T) {
+	_, _, priv := writeKeys(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	handler := DecryptHandle(priv)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	encrypted, err := Encrypt(&priv.PublicKey, []byte("payload"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(encrypted))
+	req.Header.Set(Header, "1")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "payload", res.Body.String())
+
+	encrypted, err = Encrypt(&other.PublicKey, []byte("payload"))
+	require.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(encrypted))
+	req.Header.Set(Header, "1")
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Contains(t, res.Body.String(), "doesn't match")
+
+	req = httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader([]byte("plain")))
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, "plain", res.Body.String())
+}
@@ -0,0 +1,180 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Filter отбирает метрики по префиксу имени и значения истории по времени.
+// Нулевые From и To не ограничивают интервал, обе границы включаются.
+type Filter struct {
+	Prefix string
+	From   time.Time
+	To     time.Time
+}
+
+func (f Filter) match(name string) bool {
+	return strings.HasPrefix(name, f.Prefix)
+}
+
+func (f Filter) inRange(t time.Time) bool {
+	return (f.From.IsZero() || !t.Before(f.From)) && (f.To.IsZero() || !t.After(f.To))
+}
+
+// Row - строка выгрузки: текущее значение или одно значение из истории.
+type Row struct {
+	Type  string    `json:"type"`
+	Name  string    `json:"name"`
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+func sortedMetrics(r storage.Reader, f Filter) []storage.Metrics {
+	all := r.All()
+	metrics := make([]storage.Metrics, 0, len(all))
+	for _, m := range all {
+		if f.match(m.ID) {
+			metrics = append(metrics, m)
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].MType != metrics[j].MType {
+			return metrics[i].MType < metrics[j].MType
+		}
+		return metrics[i].ID < metrics[j].ID
+	})
+	return metrics
+}
+
+// Values возвращает текущие значения метрик на момент now. Интервал
+// времени к ним не применяется.
+func Values(r storage.Reader, f Filter, now time.Time) []Row {
+	metrics := sortedMetrics(r, f)
+	rows := make([]Row, 0, len(metrics))
+	for _, m := range metrics {
+		row := Row{Type: m.MType, Name: m.ID, Time: now}
+		if m.Delta != nil {
+			row.Value = float64(*m.Delta)
+		}
+		if m.Value != nil {
+			row.Value = *m.Value
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// History возвращает значения из истории метрик, попавшие в интервал,
+// от старых к новым внутри каждой метрики.
+func History(r storage.Reader, f Filter) []Row {
+	rows := make([]Row, 0)
+	for _, m := range sortedMetrics(r, f) {
+		for _, smp := range r.History(m.MType, m.ID) {
+			if f.inRange(smp.Time) {
+				rows = append(rows, Row{Type: m.MType, Name: m.ID, Time: smp.Time, Value: smp.Value})
+			}
+		}
+	}
+	return rows
+}
+
+// Writer пишет строки выгрузки в одном из форматов.
+type Writer interface {
+	Write(row Row) error
+	Flush() error
+}
+
+// NewWriter создаёт Writer формата format. CSV начинается с заголовка
+// type,name,time,value.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"type", "name", "time", "value"}); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// ContentType возвращает Content-Type формата.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// WriteAll пишет rows в w и возвращает первую ошибку записи.
+func WriteAll(w io.Writer, format string, rows []Row) error {
+	ew, err := NewWriter(w, format)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err = ew.Write(row); err != nil {
+			return err
+		}
+	}
+	return ew.Flush()
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(row Row) error {
+	return c.w.Write([]string{
+		row.Type,
+		row.Name,
+		row.Time.UTC().Format(time.RFC3339Nano),
+		strconv.FormatFloat(row.Value, 'f', -1, 64),
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(row Row) error {
+	row.Time = row.Time.UTC()
+	return n.enc.Encode(row)
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+// ParseTime разбирает границу интервала: RFC 3339 или Unix-время в
+// секундах. Пустая строка - нулевое время.
+func ParseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,93 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/storage/storagetest"
+)
+
+var now = storagetest.Now
+
+func newFakeReader() *storagetest.Reader {
+	gauge, counter, at := storagetest.Gauge, storagetest.Counter, storagetest.At
+
+	return &storagetest.Reader{
+		Metrics: []storage.Metrics{
+			gauge("mem.used", 0.5),
+			counter("requests", 12),
+			gauge("cpu, total", 3),
+		},
+		Samples: map[string][]storage.Sample{
+			"gauge/mem.used":   {at(-20, 0.1), at(-10, 0.3), at(0, 0.5)},
+			"counter/requests": {at(-15, 10), at(0, 12)},
+		},
+	}
+}
+
+func TestValuesCSV(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, WriteAll(&b, FormatCSV, Values(newFakeReader(), Filter{}, now)))
+
+	assert.Equal(t, "type,name,time,value\n"+
+		"counter,requests,2023-11-01T12:00:00Z,12\n"+
+		"gauge,\"cpu, total\",2023-11-01T12:00:00Z,3\n"+
+		"gauge,mem.used,2023-11-01T12:00:00Z,0.5\n", b.String())
+}
+
+func TestHistoryNDJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{
+			name:   "Prefix",
+			filter: Filter{Prefix: "req"},
+			want: `{"type":"counter","name":"requests","time":"2023-11-01T11:59:45Z","value":10}` + "\n" +
+				`{"type":"counter","name":"requests","time":"2023-11-01T12:00:00Z","value":12}` + "\n",
+		},
+		{
+			name:   "Time range",
+			filter: Filter{Prefix: "mem", From: now.Add(-10 * time.Second), To: now.Add(-time.Second)},
+			want:   `{"type":"gauge","name":"mem.used","time":"2023-11-01T11:59:50Z","value":0.3}` + "\n",
+		},
+		{
+			name:   "Nothing matched",
+			filter: Filter{Prefix: "disk"},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			require.NoError(t, WriteAll(&b, FormatNDJSON, History(newFakeReader(), tt.filter)))
+			assert.Equal(t, tt.want, b.String())
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tm, err := ParseTime("1698840000")
+	require.NoError(t, err)
+	assert.True(t, tm.Equal(now))
+
+	tm, err = ParseTime("2023-11-01T15:00:00+03:00")
+	require.NoError(t, err)
+	assert.True(t, tm.Equal(now))
+
+	tm, err = ParseTime("")
+	require.NoError(t, err)
+	assert.True(t, tm.IsZero())
+
+	_, err = ParseTime("yesterday")
+	assert.Error(t, err)
+
+	_, err = NewWriter(&bytes.Buffer{}, "xlsx")
+	assert.Error(t, err)
+}
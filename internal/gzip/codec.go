@@ -0,0 +1,193 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Writer - сжимающий writer кодека. Flush нужен потоковым ответам,
+// Reset - повторному использованию из пула.
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Codec - алгоритм сжатия, соответствующий значению Content-Encoding.
+type Codec struct {
+	Name      string
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	NewWriter func(w io.Writer) (Writer, error)
+
+	// pool - закрытые writer'ы: создавать их дорого, особенно zstd и br
+	pool sync.Pool
+}
+
+func (c *Codec) getWriter(w io.Writer) (Writer, error) {
+	if zw, ok := c.pool.Get().(Writer); ok {
+		zw.Reset(w)
+		return zw, nil
+	}
+	return c.NewWriter(w)
+}
+
+// putWriter возвращает закрытый writer в пул.
+func (c *Codec) putWriter(zw Writer) {
+	c.pool.Put(zw)
+}
+
+var (
+	codecs = map[string]*Codec{}
+	// preference - порядок выбора кодека при равных q в Accept-Encoding
+	preference []string
+)
+
+// Register добавляет кодек, более ранние предпочтительнее при согласовании.
+func Register(c *Codec, aliases ...string) {
+	codecs[c.Name] = c
+	for _, a := range aliases {
+		codecs[a] = c
+	}
+	preference = append(preference, c.Name)
+}
+
+// Lookup ищет кодек по значению Content-Encoding.
+func Lookup(name string) (*Codec, bool) {
+	c, ok := codecs[strings.ToLower(strings.TrimSpace(name))]
+	return c, ok
+}
+
+func init() {
+	Register(&Codec{
+		Name: "zstd",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		},
+	})
+
+	Register(&Codec{
+		Name: "br",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return brotli.NewWriterLevel(w, brotli.DefaultCompression), nil
+		},
+	})
+
+	Register(&Codec{
+		Name: "gzip",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return gzip.NewWriter(w), nil
+		},
+	}, "x-gzip")
+
+	Register(&Codec{
+		Name: "deflate",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return flate.NewWriter(w, flate.DefaultCompression)
+		},
+	})
+}
+
+// Negotiate выбирает кодек ответа по Accept-Encoding с учётом q-значений.
+// nil - отвечать без сжатия.
+func Negotiate(acceptEncoding string) *Codec {
+	if acceptEncoding == "" {
+		return nil
+	}
+
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				} else {
+					q = 0
+				}
+			}
+		}
+
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		if c, ok := codecs[name]; ok {
+			name = c.Name
+		}
+		weights[name] = q
+	}
+
+	candidates := make([]string, 0, len(preference))
+	for _, name := range preference {
+		q, ok := weights[name]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			weights[name] = q
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return weights[candidates[i]] > weights[candidates[j]]
+	})
+	return codecs[candidates[0]]
+}
+
+// Compress сжимает data кодеком name.
+func Compress(name string, data []byte) ([]byte, error) {
+	c, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding: %s", name)
+	}
+
+	var b bytes.Buffer
+	w, err := c.getWriter(&b)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	c.putWriter(w)
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package gzip
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	type testType struct {
+		name     string
+		accept   string
+		expected string
+	}
+
+	tests := []testType{
+		{name: "Empty", accept: "", expected: ""},
+		{name: "Single", accept: "gzip", expected: "gzip"},
+		{name: "Server preference", accept: "gzip, deflate, br, zstd", expected: "zstd"},
+		{name: "Q-values", accept: "zstd;q=0.5, gzip;q=0.8, br;q=0.1", expected: "gzip"},
+		{name: "Disabled by q=0", accept: "gzip;q=0", expected: ""},
+		{name: "Alias", accept: "x-gzip", expected: "gzip"},
+		{name: "Wildcard", accept: "*", expected: "zstd"},
+		{name: "Wildcard with exclusions", accept: "*;q=0.5, zstd;q=0, br;q=0", expected: "gzip"},
+		{name: "Unknown only", accept: "compress, identity", expected: ""},
+		{name: "Bad q", accept: "br;q=abc, deflate", expected: "deflate"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Negotiate(test.accept)
+			if test.expected == "" {
+				assert.Nil(t, c)
+				return
+			}
+			require.NotNil(t, c)
+			assert.Equal(t, test.expected, c.Name)
+		})
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	payload := jsonPayload
+	h := GzipHandle(1<<20, 100)(echo)
+
+	for _, name := range []string{"gzip", "deflate", "zstd", "br"} {
+		t.Run(name, func(t *testing.T) {
+			body, err := Compress(name, payload)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(body))
+			req.Header.Set("Content-Encoding", name)
+			req.Header.Set("Accept-Encoding", name)
+			res := httptest.NewRecorder()
+
+			h.ServeHTTP(res, req)
+			require.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, name, res.Header().Get("Content-Encoding"))
+
+			c, ok := Lookup(name)
+			require.True(t, ok)
+			zr, err := c.NewReader(res.Body)
+			require.NoError(t, err)
+			data, err := io.ReadAll(zr)
+			require.NoError(t, err)
+			assert.Equal(t, payload, data)
+		})
+	}
+
+	_, err := Compress("lzma", payload)
+	assert.Error(t, err)
+}
@@ -1,22 +1,59 @@
 package gzip
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"strings"
+
+	"github.com/pavelborisofff/go-metrics/internal/selfmetrics"
 )
 
+// MinSize - ответы меньше этого размера не сжимаются: выигрыша почти нет,
+// а заголовок и словарь кодека могут сделать ответ даже больше.
+const MinSize = 1024
+
+// compressible - типы содержимого, которые имеет смысл сжимать.
+func compressible(contentType string) bool {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+
+	switch {
+	case strings.HasPrefix(ct, "text/"),
+		strings.HasSuffix(ct, "+json"),
+		strings.HasSuffix(ct, "+xml"):
+		return true
+	}
+
+	switch ct {
+	case "application/json", "application/x-ndjson", "application/javascript",
+		"application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter решает, сжимать ли ответ, только когда известны статус,
+// тип содержимого и хотя бы MinSize байт тела (или был Flush).
+// До этого заголовки не отправляются, а тело копится в buf.
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w     http.ResponseWriter
+	codec *Codec
+	zw    Writer
+
+	status   int
+	buf      []byte
+	decided  bool
+	hijacked bool
 }
 
-func newCompressWriter(w http.ResponseWriter) *compressWriter {
+func newCompressWriter(w http.ResponseWriter, c *Codec) *compressWriter {
 	return &compressWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
+		w:     w,
+		codec: c,
 	}
 }
 
@@ -24,91 +61,224 @@ func (c *compressWriter) Header() http.Header {
 	return c.w.Header()
 }
 
-func (c *compressWriter) Write(p []byte) (int, error) {
-	return c.zw.Write(p)
-}
-
 func (c *compressWriter) WriteHeader(statusCode int) {
-	if 200 <= statusCode && statusCode < 300 {
-		c.w.Header().Set("Content-Encoding", "gzip")
+	if c.decided || c.status != 0 {
+		return
 	}
-	c.w.WriteHeader(statusCode)
-}
+	c.status = statusCode
 
-// Close закрывает gzip.Writer и досылает все данные из буфера.
-func (c *compressWriter) Close() error {
-	return c.zw.Close()
+	// Ошибки, редиректы и ответы без тела не сжимаем
+	if statusCode < 200 || statusCode >= 300 || statusCode == http.StatusNoContent {
+		c.decide(false)
+	}
 }
 
-// compressReader реализует интерфейс io.ReadCloser и позволяет прозрачно для сервера
-// декомпрессировать получаемые от клиента данные
-type compressReader struct {
-	r  io.ReadCloser
-	zr *gzip.Reader
-}
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
 
-func newCompressReader(r io.ReadCloser) (*compressReader, error) {
-	zr, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) >= MinSize {
+			if err := c.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
 	}
 
-	return &compressReader{
-		r:  r,
-		zr: zr,
-	}, nil
+	if c.zw != nil {
+		return c.zw.Write(p)
+	}
+	return c.w.Write(p)
 }
 
-func (c compressReader) Read(p []byte) (n int, err error) {
-	return c.zr.Read(p)
-}
+// decide отправляет заголовки и накопленное тело, сжимая его, если можно.
+func (c *compressWriter) decide(allow bool) error {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	h := c.w.Header()
+	if h.Get("Content-Type") == "" && len(c.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(c.buf))
+	}
 
-func (c *compressReader) Close() error {
-	if err := c.r.Close(); err != nil {
+	if allow && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		if zw, err := c.codec.getWriter(c.w); err == nil {
+			c.zw = zw
+			h.Set("Content-Encoding", c.codec.Name)
+			h.Del("Content-Length")
+		}
+	}
+
+	c.w.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if c.zw != nil {
+		_, err := c.zw.Write(buf)
 		return err
 	}
-	return c.zr.Close()
+	_, err := c.w.Write(buf)
+	return err
 }
 
-func GzipHandle(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, r)
+// Flush досылает данные клиенту, нужен для потоковых ответов.
+// Размер потока заранее неизвестен, поэтому MinSize здесь не учитывается.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if err := c.decide(true); err != nil {
+			return
+		}
+	}
+	if c.zw != nil {
+		if err := c.zw.Flush(); err != nil {
 			return
 		}
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
 
-		gzw := newCompressWriter(w)
-		defer gzw.Close()
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := c.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
 
-		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
-			gzr, err := newCompressReader(r.Body)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			defer gzr.Close()
+	c.hijacked = true
+	return h.Hijack()
+}
 
-			r.Body = gzr
+// Close дописывает маленький ответ как есть или закрывает сжимающий writer
+// и возвращает его в пул.
+func (c *compressWriter) Close() error {
+	if c.hijacked {
+		return nil
+	}
+	if !c.decided {
+		// Обработчик ничего не записал - ответ отправит net/http
+		if c.status == 0 {
+			return nil
 		}
+		if err := c.decide(false); err != nil {
+			return err
+		}
+	}
+	if c.zw == nil {
+		return nil
+	}
 
-		w.Header().Set("Content-Encoding", "gzip")
-		next.ServeHTTP(gzw, r)
-	})
+	err := c.zw.Close()
+	c.codec.putWriter(c.zw)
+	c.zw = nil
+	return err
 }
 
-func CompressData(data []byte) (*bytes.Buffer, error) {
-	var compressedData bytes.Buffer
+// ErrTooLarge - распакованное тело больше лимита или сжато подозрительно сильно.
+var ErrTooLarge = errors.New("decompressed body is too large")
 
-	gz := gzip.NewWriter(&compressedData)
-	defer gz.Close()
+// ratioFloor - до этого размера степень сжатия не проверяется:
+// маленькие однообразные тела честно сжимаются очень сильно.
+const ratioFloor = 64 << 10
+
+// countingReader считает прочитанные из сети сжатые байты.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	if _, err := gz.Write(data); err != nil {
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeBody распаковывает тело целиком, прерываясь, как только размер
+// превысит maxSize или maxRatio от прочитанного сжатого. 0 - без ограничения.
+func decodeBody(c *Codec, body io.Reader, maxSize int64, maxRatio float64) ([]byte, error) {
+	compressed := &countingReader{r: body}
+	zr, err := c.NewReader(compressed)
+	if err != nil {
 		return nil, err
 	}
+	defer zr.Close()
 
-	if err := gz.Flush(); err != nil {
-		return nil, err
+	var b bytes.Buffer
+	chunk := make([]byte, 32<<10)
+	for {
+		n, err := zr.Read(chunk)
+		b.Write(chunk[:n])
+
+		size := int64(b.Len())
+		if maxSize > 0 && size > maxSize {
+			return nil, ErrTooLarge
+		}
+		if maxRatio > 0 && size > ratioFloor && float64(size) > maxRatio*float64(compressed.n) {
+			return nil, ErrTooLarge
+		}
+
+		if err == io.EOF {
+			selfmetrics.Default.ObserveDecode(compressed.n, size)
+			return b.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
+}
+
+// GzipHandle распаковывает тела запросов с любым зарегистрированным
+// Content-Encoding независимо от Accept-Encoding и сжимает ответы кодеком,
+// выбранным по Accept-Encoding.
+// Распакованное тело ограничено maxSize байтами и maxRatio от сжатого.
+func GzipHandle(maxSize int64, maxRatio float64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if enc := strings.TrimSpace(r.Header.Get("Content-Encoding")); enc != "" && !strings.EqualFold(enc, "identity") {
+				c, ok := Lookup(enc)
+				if !ok {
+					http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+					return
+				}
+
+				data, err := decodeBody(c, r.Body, maxSize, maxRatio)
+				r.Body.Close()
 
-	return &compressedData, nil
+				if err != nil {
+					var maxErr *http.MaxBytesError
+					if errors.Is(err, ErrTooLarge) || errors.As(err, &maxErr) {
+						http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+						return
+					}
+					http.Error(w, "Bad "+c.Name+" body", http.StatusBadRequest)
+					return
+				}
+
+				r.Header.Del("Content-Encoding")
+				r.Body = io.NopCloser(bytes.NewReader(data))
+				r.ContentLength = int64(len(data))
+			}
+
+			// Upgrade-соединения (WebSocket) забирают сокет, сжимать нечего
+			c := Negotiate(r.Header.Get("Accept-Encoding"))
+			if c == nil || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := newCompressWriter(w, c)
+			defer cw.Close()
+
+			w.Header().Add("Vary", "Accept-Encoding")
+			next.ServeHTTP(cw, r)
+		})
+	}
 }
@@ -0,0 +1,138 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, data []byte) []byte {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return b.Bytes()
+}
+
+// jsonPayload - тело больше MinSize, чтобы ответ сжимался.
+var jsonPayload = []byte("[" + strings.Repeat(`{"id":"Alloc","type":"gauge","value":1},`, 64) + `{"id":"PollCount","type":"counter","delta":5}]`)
+
+// echo возвращает полученное тело как есть.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+})
+
+func TestGzipHandle(t *testing.T) {
+	payload := jsonPayload
+	gzipped := compress(t, payload)
+
+	type testType struct {
+		name            string
+		contentEncoding string
+		acceptEncoding  string
+		body            []byte
+		expectedCode    int
+		expectedGzip    bool
+	}
+
+	tests := []testType{
+		{name: "Plain request, plain response", body: payload, expectedCode: http.StatusOK},
+		{name: "Plain request, gzip response", acceptEncoding: "gzip", body: payload, expectedCode: http.StatusOK, expectedGzip: true},
+		{name: "Gzip request, plain response", contentEncoding: "gzip", body: gzipped, expectedCode: http.StatusOK},
+		{name: "Gzip request, gzip response", contentEncoding: "gzip", acceptEncoding: "gzip, deflate", body: gzipped, expectedCode: http.StatusOK, expectedGzip: true},
+		{name: "x-gzip request", contentEncoding: "x-gzip", body: gzipped, expectedCode: http.StatusOK},
+		{name: "Identity request", contentEncoding: "identity", body: payload, expectedCode: http.StatusOK},
+		{name: "Corrupt header", contentEncoding: "gzip", body: payload, expectedCode: http.StatusBadRequest},
+		{name: "Corrupt header, gzip response", contentEncoding: "gzip", acceptEncoding: "gzip", body: payload, expectedCode: http.StatusBadRequest},
+		{name: "Truncated stream", contentEncoding: "gzip", body: gzipped[:len(gzipped)-6], expectedCode: http.StatusBadRequest},
+		{name: "Unknown encoding", contentEncoding: "compress", body: payload, expectedCode: http.StatusUnsupportedMediaType},
+	}
+
+	h := GzipHandle(1<<20, 100)(echo)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(test.body))
+			if test.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", test.contentEncoding)
+			}
+			if test.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			res := httptest.NewRecorder()
+
+			h.ServeHTTP(res, req)
+			require.Equal(t, test.expectedCode, res.Code)
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+
+			body := res.Body.Bytes()
+			if test.expectedGzip {
+				assert.Equal(t, "gzip", res.Header().Get("Content-Encoding"))
+				zr, err := gzip.NewReader(res.Body)
+				require.NoError(t, err)
+				body, err = io.ReadAll(zr)
+				require.NoError(t, err)
+			} else {
+				assert.Empty(t, res.Header().Get("Content-Encoding"))
+			}
+			assert.Equal(t, payload, body)
+		})
+	}
+}
+
+func TestGzipHandleLimits(t *testing.T) {
+	zeros := compress(t, make([]byte, 1<<20))
+
+	type testType struct {
+		name         string
+		maxSize      int64
+		maxRatio     float64
+		body         []byte
+		expectedCode int
+	}
+
+	tests := []testType{
+		{name: "Over size", maxSize: 1 << 10, body: compress(t, []byte(strings.Repeat("metric ", 1<<10))), expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "Over ratio", maxRatio: 100, body: zeros, expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "Small body ignores ratio", maxRatio: 2, body: compress(t, make([]byte, 1<<10)), expectedCode: http.StatusOK},
+		{name: "No limits", body: zeros, expectedCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(test.body))
+			req.Header.Set("Content-Encoding", "gzip")
+			res := httptest.NewRecorder()
+
+			GzipHandle(test.maxSize, test.maxRatio)(echo).ServeHTTP(res, req)
+			assert.Equal(t, test.expectedCode, res.Code)
+		})
+	}
+}
+
+func TestGzipHandleUpgrade(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Upgrade", "websocket")
+	res := httptest.NewRecorder()
+
+	GzipHandle(0, 0)(echo).ServeHTTP(res, req)
+	assert.Empty(t, res.Header().Get("Content-Encoding"))
+}
@@ -0,0 +1,149 @@
+package gzip
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressDecision(t *testing.T) {
+	type testType struct {
+		name         string
+		handler      http.HandlerFunc
+		expectedGzip bool
+	}
+
+	write := func(status int, contentType string, body []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.WriteHeader(status)
+			w.Write(body)
+		}
+	}
+
+	tests := []testType{
+		{name: "Large JSON", handler: write(http.StatusOK, "application/json", jsonPayload), expectedGzip: true},
+		{name: "Large HTML without Content-Type", handler: write(http.StatusOK, "", []byte("<html>"+strings.Repeat("<p>metric</p>", 200))), expectedGzip: true},
+		{name: "Small text value", handler: write(http.StatusOK, "text/plain", []byte("42.5")), expectedGzip: false},
+		{name: "Error", handler: write(http.StatusNotFound, "text/plain", []byte(strings.Repeat("Not found ", 200))), expectedGzip: false},
+		{name: "Binary", handler: write(http.StatusOK, "image/png", jsonPayload), expectedGzip: false},
+		{name: "Already encoded", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			write(http.StatusOK, "application/json", jsonPayload)(w, r)
+		}, expectedGzip: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			res := httptest.NewRecorder()
+
+			GzipHandle(0, 0)(test.handler).ServeHTTP(res, req)
+
+			assert.Equal(t, "Accept-Encoding", res.Header().Get("Vary"))
+			if test.expectedGzip {
+				assert.Equal(t, "gzip", res.Header().Get("Content-Encoding"))
+				zr, err := gzip.NewReader(res.Body)
+				require.NoError(t, err)
+				_, err = io.ReadAll(zr)
+				require.NoError(t, err)
+			} else {
+				assert.NotEqual(t, "gzip", res.Header().Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res := httptest.NewRecorder()
+
+	GzipHandle(0, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+
+		// Первое событие уже у клиента, хотя оно меньше MinSize
+		assert.True(t, res.Flushed)
+		assert.Equal(t, "gzip", res.Header().Get("Content-Encoding"))
+		assert.NotZero(t, res.Body.Len())
+	})).ServeHTTP(res, req)
+}
+
+func TestCompressHijack(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	GzipHandle(0, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Hijacker)
+		assert.True(t, ok)
+
+		// httptest.ResponseRecorder не умеет Hijack
+		_, _, err := w.(http.Hijacker).Hijack()
+		assert.ErrorIs(t, err, http.ErrNotSupported)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func benchmarkHandle(b *testing.B, body []byte) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h := GzipHandle(0, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+// naiveHandle - прежнее поведение: новый gzip.Writer на каждый ответ,
+// сжимается всё подряд.
+func naiveHandle(body []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		defer zw.Close()
+		w.Header().Set("Content-Type", "application/json")
+		zw.Write(body)
+	})
+}
+
+func benchmarkNaive(b *testing.B, body []byte) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	h := naiveHandle(body)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkSmallResponse(b *testing.B) {
+	benchmarkHandle(b, []byte("42.5"))
+}
+
+func BenchmarkSmallResponseNaive(b *testing.B) {
+	benchmarkNaive(b, []byte("42.5"))
+}
+
+func BenchmarkLargeResponse(b *testing.B) {
+	benchmarkHandle(b, jsonPayload)
+}
+
+func BenchmarkLargeResponseNaive(b *testing.B) {
+	benchmarkNaive(b, jsonPayload)
+}
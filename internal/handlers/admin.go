@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pavelborisofff/go-metrics/internal/config"
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+)
+
+const (
+	CodeRestartRequired = "restart_required"
+	CodeInvalidConfig   = "invalid_config"
+	CodeNoStoreFile     = "no_store_file"
+	CodeInvalidSnapshot = "invalid_snapshot"
+)
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+// ReloadHandler перечитывает конфигурацию сервера функцией reload.
+// Изменения, которым нужен перезапуск, отклоняются с 409.
+func ReloadHandler(reload func() error) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if err := reload(); err != nil {
+			logger.FromContext(req.Context()).Warn("Config reload rejected", zap.Error(err))
+			if errors.Is(err, config.ErrRestartRequired) {
+				jsonError(res, http.StatusConflict, APIError{Code: CodeRestartRequired, Message: err.Error()})
+				return
+			}
+			jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidConfig, Message: err.Error()})
+			return
+		}
+
+		audit(req, "reload")
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		res.Write([]byte(`{"status":"reloaded"}`))
+	}
+}
+
+// SnapshotResponse - ответ /admin/snapshot и /admin/restore.
+type SnapshotResponse struct {
+	Path       string  `json:"path"`
+	Size       int64   `json:"size,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Counters   *int    `json:"counters,omitempty"`
+	Gauges     *int    `json:"gauges,omitempty"`
+}
+
+// SnapshotHandler сохраняет хранилище в file не дожидаясь интервала.
+func SnapshotHandler(file string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		log := logger.FromContext(req.Context())
+
+		if file == "" {
+			jsonError(res, http.StatusConflict, APIError{Code: CodeNoStoreFile, Message: "Store file is not configured"})
+			return
+		}
+
+		start := time.Now()
+		size, err := s.Save(req.Context(), file)
+		if err != nil {
+			log.Error("Error saving metrics", zap.Error(err))
+			jsonError(res, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: "Error saving metrics"})
+			return
+		}
+
+		audit(req, "snapshot", zap.String("path", file))
+		writeJSON(res, http.StatusOK, SnapshotResponse{
+			Path:       file,
+			Size:       size,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		})
+	}
+}
+
+type restoreRequest struct {
+	Path string `json:"path"`
+}
+
+// snapshotPath разрешает path внутри dir. Пустой path - файл хранилища,
+// выйти за пределы dir нельзя.
+func snapshotPath(dir, file, path string) (string, bool) {
+	if path == "" {
+		return file, true
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path, true
+}
+
+// RestoreHandler заменяет хранилище снимком. Путь в теле {"path": "..."}
+// берётся относительно каталога file и не может из него выходить; без
+// пути используется сам file.
+func RestoreHandler(file string) http.HandlerFunc {
+	dir := filepath.Dir(file)
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		log := logger.FromContext(req.Context())
+
+		if file == "" {
+			jsonError(res, http.StatusConflict, APIError{Code: CodeNoStoreFile, Message: "Store file is not configured"})
+			return
+		}
+
+		var r restoreRequest
+		var b bytes.Buffer
+		if !readBody(res, req, &b) {
+			return
+		}
+		if b.Len() > 0 {
+			if err := json.Unmarshal(b.Bytes(), &r); err != nil {
+				jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: "Error unmarshal"})
+				return
+			}
+		}
+
+		path, ok := snapshotPath(dir, file, r.Path)
+		if !ok {
+			jsonError(res, http.StatusForbidden, APIError{Code: CodeForbidden, Message: "Snapshot must be inside " + dir, Field: "path"})
+			return
+		}
+
+		start := time.Now()
+		err := s.Restore(req.Context(), path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			jsonError(res, http.StatusNotFound, APIError{Code: CodeNotFound, Message: "Snapshot not found", Field: "path"})
+			return
+		case err != nil:
+			log.Debug("Error restoring snapshot", zap.String("path", path), zap.Error(err))
+			jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidSnapshot, Message: err.Error(), Field: "path"})
+			return
+		}
+
+		// Значения сменились целиком, подписчики перечитают хранилище
+		hub.Resync()
+
+		audit(req, "restore", zap.String("path", path))
+		st := s.Stats()
+		writeJSON(res, http.StatusOK, SnapshotResponse{
+			Path:       path,
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Counters:   &st.Counters,
+			Gauges:     &st.Gauges,
+		})
+	}
+}
+
+// StatsHandler отдаёт storage.Stats.
+func StatsHandler(res http.ResponseWriter, _ *http.Request) {
+	writeJSON(res, http.StatusOK, s.Stats())
+}
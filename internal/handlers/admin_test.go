@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "metrics.json")
+
+	// Хранилище общее для тестов пакета, после теста возвращаем его как было
+	backup := filepath.Join(t.TempDir(), "backup.json")
+	_, err := s.Save(context.Background(), backup)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Restore(context.Background(), backup) })
+
+	s.UpdateGauge("adminSnapshotGauge", 1)
+
+	res := httptest.NewRecorder()
+	SnapshotHandler(file)(res, httptest.NewRequest(http.MethodPost, "/admin/snapshot", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	var snap SnapshotResponse
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &snap))
+	assert.Equal(t, file, snap.Path)
+	assert.Positive(t, snap.Size)
+
+	res = httptest.NewRecorder()
+	StatsHandler(res, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	var st storage.Stats
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &st))
+	assert.NotNil(t, st.LastSave)
+	assert.Positive(t, st.Gauges)
+
+	// После изменения снимок возвращает прежнее значение, подписчики его получают
+	s.UpdateGauge("adminSnapshotGauge", 2)
+	sub := hub.Subscribe(stream.Filter{Name: "adminSnapshot*"})
+	defer hub.Unsubscribe(sub)
+
+	res = httptest.NewRecorder()
+	RestoreHandler(file)(res, httptest.NewRequest(http.MethodPost, "/admin/restore", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	v, _ := s.GetGauge("adminSnapshotGauge")
+	assert.Equal(t, storage.Gauge(1), v)
+	select {
+	case <-sub.Resync:
+	default:
+		t.Fatal("restore was not published")
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0644))
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"Relative name", `{"path":"metrics.json"}`, http.StatusOK},
+		{"Outside store dir", `{"path":"../etc/passwd"}`, http.StatusForbidden},
+		{"Missing", `{"path":"missing.json"}`, http.StatusNotFound},
+		{"Broken snapshot", `{"path":"broken.json"}`, http.StatusBadRequest},
+		{"Bad body", `path`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			RestoreHandler(file)(res, httptest.NewRequest(http.MethodPost, "/admin/restore", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.want, res.Code)
+		})
+	}
+}
+
+// Чтение метрик во время restore не должно гоняться с заменой карт
+// хранилища, проверяется с -race.
+func TestRestoreConcurrentReads(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "metrics.json")
+
+	backup := filepath.Join(t.TempDir(), "backup.json")
+	_, err := s.Save(context.Background(), backup)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Restore(context.Background(), backup) })
+
+	s.UpdateGauge("raceGauge", 1)
+	s.IncrementCounter("raceCounter", 1)
+	_, err = s.Save(context.Background(), file)
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/value/{metric-type}/{metric-name}", ValueHandler)
+	r.Post("/value/", ValueJSONHandler)
+	r.Get("/metrics", MetricsHandler)
+	r.Post("/admin/restore", RestoreHandler(file))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/admin/restore", nil))
+			assert.Equal(t, http.StatusOK, res.Code)
+		}
+	}()
+
+	reads := []func() *http.Request{
+		func() *http.Request { return httptest.NewRequest(http.MethodGet, "/value/gauge/raceGauge", nil) },
+		func() *http.Request { return httptest.NewRequest(http.MethodGet, "/value/counter/raceCounter", nil) },
+		func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(`{"id":"raceGauge","type":"gauge"}`))
+		},
+		func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(`{"id":"raceCounter","type":"counter"}`))
+		},
+		func() *http.Request { return httptest.NewRequest(http.MethodGet, "/metrics", nil) },
+	}
+	for _, read := range reads {
+		wg.Add(1)
+		go func(read func() *http.Request) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				res := httptest.NewRecorder()
+				r.ServeHTTP(res, read())
+				assert.Equal(t, http.StatusOK, res.Code)
+			}
+		}(read)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
+)
+
+const (
+	wsWriteWait       = 10 * time.Second
+	wsPongWait        = 60 * time.Second
+	wsPingPeriod      = wsPongWait * 9 / 10
+	dashboardHistory  = 60
+	dashboardSnapshot = "snapshot"
+	dashboardUpdate   = "update"
+	dashboardDelete   = "delete"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type dashboardMessage struct {
+	Type    string                      `json:"type"`
+	Metrics []storage.Metrics           `json:"metrics,omitempty"`
+	History map[string][]storage.Sample `json:"history,omitempty"`
+	Metric  *storage.Metrics            `json:"metric,omitempty"`
+	Time    time.Time                   `json:"time"`
+}
+
+// dashboardSnapshotMessage - снимок рядов хранилища. История ряда лежит
+// по ключу type/name/agent, у ряда без агента agent пустой.
+func dashboardSnapshotMessage() dashboardMessage {
+	all := s.Series()
+	history := make(map[string][]storage.Sample, len(all))
+
+	for _, m := range all {
+		agent := m.Labels[storage.LabelAgent]
+		h := s.SeriesHistory(m.MType, m.ID, agent)
+		if len(h) > dashboardHistory {
+			h = h[len(h)-dashboardHistory:]
+		}
+		history[m.MType+"/"+m.ID+"/"+agent] = h
+	}
+
+	return dashboardMessage{
+		Type:    dashboardSnapshot,
+		Metrics: all,
+		History: history,
+		Time:    time.Now(),
+	}
+}
+
+// DashboardWSHandler отправляет дашборду снимок рядов хранилища с
+// историей, а затем каждое обновление и удаление метрик по WebSocket.
+func DashboardWSHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	conn, err := upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		log.Debug("Error upgrade", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	sub := hub.Subscribe(stream.Filter{})
+	defer hub.Unsubscribe(sub)
+
+	// Читаем соединение, чтобы обрабатывать pong и узнать о закрытии
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		conn.SetReadLimit(512)
+		_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err = conn.WriteJSON(dashboardSnapshotMessage()); err != nil {
+		log.Debug("Error write snapshot", zap.Error(err))
+		return
+	}
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-hub.Done():
+			// Соединение перехвачено, Shutdown его не закроет
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"),
+				time.Now().Add(wsWriteWait))
+			return
+		case <-ping.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err = conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-sub.Resync:
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err = conn.WriteJSON(dashboardSnapshotMessage()); err != nil {
+				log.Debug("Error write snapshot", zap.Error(err))
+				return
+			}
+		case m, ok := <-sub.C:
+			if !ok {
+				log.Debug("Slow dashboard dropped", zap.String("remote", req.RemoteAddr))
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"),
+					time.Now().Add(wsWriteWait))
+				return
+			}
+
+			msgType := dashboardUpdate
+			if stream.IsRemoved(m) {
+				msgType = dashboardDelete
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err = conn.WriteJSON(dashboardMessage{Type: msgType, Metric: &m, Time: time.Now()}); err != nil {
+				log.Debug("Error write update", zap.Error(err))
+				return
+			}
+		}
+	}
+}
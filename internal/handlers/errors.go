@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Коды ошибок JSON API, на них и стоит опираться клиентам, а не на текст.
+const (
+	CodeBadRequest   = "bad_request"
+	CodeInvalidJSON  = "invalid_json"
+	CodeInvalidType  = "invalid_type"
+	CodeInvalidValue = "invalid_value"
+	CodeNotFound     = "not_found"
+	CodeForbidden    = "forbidden"
+	CodeTooLarge     = "body_too_large"
+	CodeInternal     = "internal"
+)
+
+// APIError - ошибка JSON API. Field - поле запроса, из-за которого
+// запрос отклонён, если оно одно.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ErrorResponse - тело ответа JSON API с ошибкой.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// errorReply отвечает ошибкой: URL-API текстом, остальные обработчики JSON.
+type errorReply func(res http.ResponseWriter, status int, e APIError)
+
+func textError(res http.ResponseWriter, status int, e APIError) {
+	http.Error(res, e.Message, status)
+}
+
+func jsonError(res http.ResponseWriter, status int, e APIError) {
+	data, err := json.Marshal(ErrorResponse{Error: e})
+	if err != nil {
+		http.Error(res, e.Message, status)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(status)
+	res.Write(data)
+}
+
+// badRequest отвечает 400 с APIError из err, если он там есть.
+func badRequest(res http.ResponseWriter, err error) {
+	var e *APIError
+	if !errors.As(err, &e) {
+		e = &APIError{Code: CodeBadRequest, Message: err.Error()}
+	}
+	jsonError(res, http.StatusBadRequest, *e)
+}
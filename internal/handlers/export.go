@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pavelborisofff/go-metrics/internal/export"
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+)
+
+// parseExport читает параметры выгрузки: format (csv по умолчанию или
+// ndjson), prefix, from и to (RFC 3339 или Unix-секунды).
+func parseExport(req *http.Request) (string, export.Filter, error) {
+	v := req.URL.Query()
+
+	format := v.Get("format")
+	switch format {
+	case "":
+		format = export.FormatCSV
+	case export.FormatCSV, export.FormatNDJSON:
+	default:
+		return "", export.Filter{}, &APIError{Code: CodeBadRequest, Message: "Format must be csv or ndjson", Field: "format"}
+	}
+
+	f := export.Filter{Prefix: v.Get("prefix")}
+	var err error
+	if f.From, err = export.ParseTime(v.Get("from")); err != nil {
+		return "", f, &APIError{Code: CodeBadRequest, Message: "Bad from: " + err.Error(), Field: "from"}
+	}
+	if f.To, err = export.ParseTime(v.Get("to")); err != nil {
+		return "", f, &APIError{Code: CodeBadRequest, Message: "Bad to: " + err.Error(), Field: "to"}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return "", f, &APIError{Code: CodeBadRequest, Message: "to is before from", Field: "to"}
+	}
+
+	return format, f, nil
+}
+
+func writeExport(res http.ResponseWriter, req *http.Request, format, name string, rows []export.Row) {
+	res.Header().Set("Content-Type", export.ContentType(format))
+	res.Header().Set("Content-Disposition", `attachment; filename="`+name+`.`+format+`"`)
+	res.WriteHeader(http.StatusOK)
+
+	if err := export.WriteAll(res, format, rows); err != nil {
+		logger.FromContext(req.Context()).Debug("Error writing export", zap.Error(err))
+	}
+}
+
+// ValuesExportHandler отдаёт текущие значения метрик в CSV или NDJSON.
+func ValuesExportHandler(res http.ResponseWriter, req *http.Request) {
+	format, f, err := parseExport(req)
+	if err != nil {
+		badRequest(res, err)
+		return
+	}
+
+	writeExport(res, req, format, "values", export.Values(s, f, time.Now()))
+}
+
+// HistoryExportHandler отдаёт значения из истории метрик с отметками
+// времени. История хранится только в памяти, после перезапуска она пуста.
+func HistoryExportHandler(res http.ResponseWriter, req *http.Request) {
+	format, f, err := parseExport(req)
+	if err != nil {
+		badRequest(res, err)
+		return
+	}
+
+	writeExport(res, req, format, "history", export.History(s, f))
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportHandlers(t *testing.T) {
+	t.Cleanup(func() { s.DeleteGauge("csvExportGauge") })
+	s.UpdateGauge("csvExportGauge", 1)
+	s.UpdateGauge("csvExportGauge", 2)
+
+	res := httptest.NewRecorder()
+	ValuesExportHandler(res, httptest.NewRequest(http.MethodGet, "/api/v1/export/values?prefix=csvExport", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", res.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(res.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "type,name,time,value", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "gauge,csvExportGauge,"))
+	assert.True(t, strings.HasSuffix(lines[1], ",2"))
+
+	res = httptest.NewRecorder()
+	HistoryExportHandler(res, httptest.NewRequest(http.MethodGet, "/api/v1/export/history?format=ndjson&prefix=csvExport&from=1700000000", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/x-ndjson", res.Header().Get("Content-Type"))
+	lines = strings.Split(strings.TrimSpace(res.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	var row struct {
+		Name  string  `json:"name"`
+		Value float64 `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &row))
+	assert.Equal(t, "csvExportGauge", row.Name)
+	assert.Equal(t, 2.0, row.Value)
+
+	tests := []struct {
+		name  string
+		query string
+		field string
+	}{
+		{"Bad format", "?format=xlsx", "format"},
+		{"Bad from", "?from=yesterday", "from"},
+		{"Bad to", "?to=tomorrow", "to"},
+		{"Reversed range", "?from=1700000100&to=1700000000", "to"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			HistoryExportHandler(res, httptest.NewRequest(http.MethodGet, "/api/v1/export/history"+tt.query, nil))
+			require.Equal(t, http.StatusBadRequest, res.Code)
+			var e ErrorResponse
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &e))
+			assert.Equal(t, tt.field, e.Error.Field)
+		})
+	}
+}
@@ -4,44 +4,123 @@ import (
 	"bytes"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+	"github.com/pavelborisofff/go-metrics/internal/limit"
 	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/selfmetrics"
 	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
 )
 
-//go:embed templates/metrics.html
-var htmlMetrics string
+//go:embed templates/dashboard.html
+var htmlDashboard []byte
 
-var (
-	s   = storage.NewMemStorage()
-	log = logger.GetLogger()
-)
+var s = storage.NewMemStorage()
+
+func init() {
+	selfmetrics.Default.GaugeFunc("storage_series", "Series in storage.", func() float64 {
+		counters, gauges := s.Len()
+		return float64(counters + gauges)
+	})
+}
+
+// allowed отвечает 403, если токен запроса не может изменять метрику name.
+func allowed(res http.ResponseWriter, req *http.Request, name string, reply errorReply) bool {
+	log := logger.FromContext(req.Context())
+
+	if strings.HasPrefix(name, selfmetrics.Prefix) {
+		msg := fmt.Sprintf("Reserved metric name: %s", name)
+		log.Debug(msg)
+		reply(res, http.StatusForbidden, APIError{Code: CodeForbidden, Message: msg, Field: "id"})
+		return false
+	}
+	if auth.Allowed(req.Context(), name) {
+		return true
+	}
+
+	msg := fmt.Sprintf("Forbidden metric: %s", name)
+	log.Debug(msg)
+	reply(res, http.StatusForbidden, APIError{Code: CodeForbidden, Message: msg, Field: "id"})
+	return false
+}
+
+// readBody читает тело JSON-запроса, отвечая 413 при превышении limit.Body
+// и 400 при остальных ошибках.
+func readBody(res http.ResponseWriter, req *http.Request, b *bytes.Buffer) bool {
+	log := logger.FromContext(req.Context())
+
+	_, err := b.ReadFrom(req.Body)
+	if err == nil {
+		return true
+	}
+
+	log.Debug("Error read body", zap.Error(err))
+	if limit.TooLarge(err) {
+		jsonError(res, http.StatusRequestEntityTooLarge, APIError{Code: CodeTooLarge, Message: "Request body too large"})
+		return false
+	}
+	jsonError(res, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "Error read body"})
+	return false
+}
+
+// audit пишет, каким токеном выполнено изменение. Без аутентификации молчит.
+func audit(req *http.Request, action string, fields ...zap.Field) {
+	log := logger.FromContext(req.Context())
+
+	t := auth.FromContext(req.Context())
+	if t == nil {
+		return
+	}
+
+	log.Info("audit", append([]zap.Field{
+		zap.String("token", t.Name),
+		zap.String("action", action),
+		zap.String("remote", req.RemoteAddr),
+	}, fields...)...)
+}
+
+// MainHandler отдаёт дашборд, данные он получает через DashboardWSHandler.
+// agentOf возвращает идентификатор агента из X-Agent-ID, по нему
+// хранилище ведёт ряды метрики (storage.LabelAgent).
+func agentOf(req *http.Request) string {
+	return req.Header.Get(limit.AgentHeader)
+}
+
+func MainHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
 
-func MainHandler(res http.ResponseWriter, _ *http.Request) {
 	res.Header().Set("Content-Type", "text/html; charset=utf-8")
 	res.WriteHeader(http.StatusOK)
-	tmpl := template.Must(template.New("metrics").Parse(htmlMetrics))
-	err := tmpl.Execute(res, s)
 
+	_, err := res.Write(htmlDashboard)
 	if err != nil {
-		log.Error("Error execute template", zap.Error(err))
-		http.Error(res, "Error execute template", http.StatusInternalServerError)
+		log.Error("Error write dashboard", zap.Error(err))
 	}
 }
 
 func UpdateHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
 	metricType := chi.URLParam(req, "metric-type")
 	metricName := chi.URLParam(req, "metric-name")
 	metricValue := chi.URLParam(req, "metric-value")
 
+	if !allowed(res, req, metricName, textError) {
+		return
+	}
+
 	switch metricType {
 	case storage.CounterType:
 		v, err := strconv.ParseUint(metricValue, 10, 64)
@@ -53,7 +132,8 @@ func UpdateHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		s.IncrementCounter(metricName, storage.Counter(v))
+		s.IncrementCounterFrom(agentOf(req), metricName, storage.Counter(v))
+		publish(storage.CounterType, metricName, agentOf(req))
 		log.Debug("Counter change", zap.String("name", metricName), zap.Uint64("value", v))
 
 	case storage.GaugeType:
@@ -66,7 +146,8 @@ func UpdateHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		s.UpdateGauge(metricName, storage.Gauge(v))
+		s.UpdateGaugeFrom(agentOf(req), metricName, storage.Gauge(v))
+		publish(storage.GaugeType, metricName, agentOf(req))
 		log.Debug("Gauge change", zap.String("name", metricName), zap.Float64("value", v))
 
 	default:
@@ -76,68 +157,80 @@ func UpdateHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	selfmetrics.Default.Ingested("http", 1)
+	audit(req, "update", zap.String("type", metricType), zap.String("name", metricName), zap.String("value", metricValue))
 	res.WriteHeader(http.StatusOK)
 }
 
 func UpdateJSONHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
 	var m storage.Metrics
 	var b bytes.Buffer
 
-	_, err := b.ReadFrom(req.Body)
-	if err != nil {
-		msg := "Error read body"
-		log.Debug("Error read body", zap.Error(err))
-		http.Error(res, msg, http.StatusBadRequest)
+	if !readBody(res, req, &b) {
 		return
 	}
 
-	err = json.Unmarshal(b.Bytes(), &m)
+	err := json.Unmarshal(b.Bytes(), &m)
 	if err != nil {
 		msg := "Error unmarshal"
-		log.Debug("Error unmarshal", zap.Error(err))
-		http.Error(res, msg, http.StatusBadRequest)
+		log.Debug(msg, zap.Error(err))
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: msg})
 		return
 	}
 
-	switch m.MType {
-	case storage.CounterType:
-		if m.Delta == nil {
-			msg := fmt.Sprintf("Bad Counter's value: %s", m.ID)
-			log.Debug(msg)
-			http.Error(res, msg, http.StatusBadRequest)
-			return
-		}
+	if !allowed(res, req, m.ID, jsonError) {
+		return
+	}
 
-		s.IncrementCounter(m.ID, storage.Counter(*m.Delta))
-		msg := fmt.Sprintf("Counter %s shanged to %d", m.ID, *m.Delta)
-		log.Debug(msg)
-		res.WriteHeader(http.StatusOK)
-	case storage.GaugeType:
-		if m.Value == nil {
-			msg := fmt.Sprintf("Bad Gauge's value: %s", m.ID)
-			log.Debug(msg)
-			http.Error(res, msg, http.StatusBadRequest)
-			return
+	_, span := tracing.Start(req.Context(), "storage.ApplyMetrics", attribute.Int("metrics", 1))
+	_, err = s.ApplyMetricsFrom(agentOf(req), []storage.Metrics{m})
+	tracing.End(span, err)
+	if err != nil {
+		var e APIError
+		switch {
+		case errors.Is(err, storage.ErrBadType):
+			e = APIError{Code: CodeInvalidType, Message: fmt.Sprintf("Bad metric's type: %s", m.MType), Field: "type"}
+		case m.MType == storage.CounterType:
+			e = APIError{Code: CodeInvalidValue, Message: fmt.Sprintf("Bad Counter's value: %s", m.ID), Field: "delta"}
+		default:
+			e = APIError{Code: CodeInvalidValue, Message: fmt.Sprintf("Bad Gauge's value: %s", m.ID), Field: "value"}
 		}
-
-		s.UpdateGauge(m.ID, storage.Gauge(*m.Value))
-		msg := fmt.Sprintf("Gauge %s updated to %f", m.ID, *m.Value)
-		log.Debug(msg)
-		res.WriteHeader(http.StatusOK)
-	default:
-		msg := fmt.Sprintf("Bad metric's type: %s", m.MType)
-		log.Debug(msg)
-		http.Error(res, msg, http.StatusBadRequest)
+		log.Debug(e.Message, zap.Error(err))
+		jsonError(res, http.StatusBadRequest, e)
 		return
 	}
+
+	publish(m.MType, m.ID, agentOf(req))
+	selfmetrics.Default.Ingested("http", 1)
+	log.Debug("Metric updated", zap.String("type", m.MType), zap.String("name", m.ID))
+	audit(req, "update", zap.String("type", m.MType), zap.String("name", m.ID))
+	res.WriteHeader(http.StatusOK)
 }
 
-func MetricsHandler(res http.ResponseWriter, _ *http.Request) {
-	data, err := json.Marshal(s)
+func MetricsHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	// Копия под блокировкой хранилища: карты может подменить restore
+	snap := struct {
+		Counter map[string]storage.Counter `json:"counter"`
+		Gauge   map[string]storage.Gauge   `json:"gauge"`
+	}{make(map[string]storage.Counter), make(map[string]storage.Gauge)}
+	for _, m := range s.All() {
+		switch m.MType {
+		case storage.CounterType:
+			snap.Counter[m.ID] = storage.Counter(*m.Delta)
+		case storage.GaugeType:
+			snap.Gauge[m.ID] = storage.Gauge(*m.Value)
+		}
+	}
+
+	data, err := json.Marshal(snap)
 	if err != nil {
 		msg := "Error marshal"
 		log.Debug(msg, zap.Error(err))
-		http.Error(res, msg, http.StatusInternalServerError)
+		jsonError(res, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: msg})
 		return
 	}
 
@@ -147,20 +240,20 @@ func MetricsHandler(res http.ResponseWriter, _ *http.Request) {
 
 	_, err = res.Write(data)
 	if err != nil {
-		msg := "Error write"
 		log.Debug("Error write", zap.Error(err))
-		http.Error(res, msg, http.StatusInternalServerError)
 		return
 	}
 }
 
 func ValueHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
 	metricType := chi.URLParam(req, "metric-type")
 	metricName := chi.URLParam(req, "metric-name")
 
 	switch metricType {
 	case storage.CounterType:
-		if v, ok := s.CounterStorage[metricName]; ok {
+		if v, ok := s.GetCounter(metricName); ok {
 			_, err := io.WriteString(res, fmt.Sprintf("%v", v))
 			if err != nil {
 				msg := "Error write"
@@ -175,7 +268,7 @@ func ValueHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	case storage.GaugeType:
-		if v, ok := s.GaugeStorage[metricName]; ok {
+		if v, ok := s.GetGauge(metricName); ok {
 			_, err := io.WriteString(res, fmt.Sprintf("%v", v))
 			if err != nil {
 				msg := "Error write"
@@ -198,50 +291,48 @@ func ValueHandler(res http.ResponseWriter, req *http.Request) {
 }
 
 func ValueJSONHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
 	var m storage.Metrics
 	var b bytes.Buffer
 
-	_, err := b.ReadFrom(req.Body)
-	if err != nil {
-		msg := "Error read body"
-		log.Debug(msg, zap.Error(err))
-		http.Error(res, msg, http.StatusBadRequest)
+	if !readBody(res, req, &b) {
 		return
 	}
 
-	err = json.Unmarshal(b.Bytes(), &m)
+	err := json.Unmarshal(b.Bytes(), &m)
 	if err != nil {
 		msg := "Error unmarshal"
 		log.Debug(msg, zap.Error(err))
-		http.Error(res, msg, http.StatusBadRequest)
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: msg})
 		return
 	}
 
 	switch m.MType {
 	case storage.CounterType:
-		if v, ok := s.CounterStorage[m.ID]; ok {
+		if v, ok := s.GetCounter(m.ID); ok {
 			m.Delta = new(int64)
 			*m.Delta = int64(v)
 		} else {
 			msg := "Not found"
 			log.Debug(msg, zap.String("name", m.ID))
-			http.Error(res, msg, http.StatusNotFound)
+			jsonError(res, http.StatusNotFound, APIError{Code: CodeNotFound, Message: msg, Field: "id"})
 			return
 		}
 	case storage.GaugeType:
-		if v, ok := s.GaugeStorage[m.ID]; ok {
+		if v, ok := s.GetGauge(m.ID); ok {
 			m.Value = new(float64)
 			*m.Value = float64(v)
 		} else {
 			msg := "Not found"
 			log.Debug(msg, zap.String("name", m.ID))
-			http.Error(res, msg, http.StatusNotFound)
+			jsonError(res, http.StatusNotFound, APIError{Code: CodeNotFound, Message: msg, Field: "id"})
 			return
 		}
 	default:
 		msg := "Bad metric's type"
 		log.Debug(msg, zap.String("type", m.MType))
-		http.Error(res, msg, http.StatusBadRequest)
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidType, Message: msg, Field: "type"})
 		return
 	}
 
@@ -249,7 +340,123 @@ func ValueJSONHandler(res http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		msg := "Error marshal"
 		log.Debug(msg, zap.Error(err))
-		http.Error(res, msg, http.StatusInternalServerError)
+		jsonError(res, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(resJSON)
+	if err != nil {
+		return
+	}
+}
+
+type deleteRequest struct {
+	Pattern string `json:"pattern"`        // glob-шаблон имени метрики
+	MType   string `json:"type,omitempty"` // gauge, counter или пусто для обоих типов
+}
+
+func DeleteHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	metricType := chi.URLParam(req, "metric-type")
+	metricName := chi.URLParam(req, "metric-name")
+
+	if !allowed(res, req, metricName, textError) {
+		return
+	}
+
+	var ok bool
+
+	switch metricType {
+	case storage.CounterType:
+		ok = s.DeleteCounter(metricName)
+	case storage.GaugeType:
+		ok = s.DeleteGauge(metricName)
+	default:
+		msg := "Bad metric's type"
+		log.Debug(msg, zap.String("type", metricType))
+		http.Error(res, msg, http.StatusBadRequest)
+		return
+	}
+
+	if !ok {
+		msg := "Not found"
+		log.Debug(msg, zap.String("name", metricName))
+		http.Error(res, msg, http.StatusNotFound)
+		return
+	}
+
+	hub.Publish(stream.Removed(metricType, metricName))
+
+	log.Debug("Metric deleted", zap.String("type", metricType), zap.String("name", metricName))
+	audit(req, "delete", zap.String("type", metricType), zap.String("name", metricName))
+	res.WriteHeader(http.StatusOK)
+}
+
+func DeleteJSONHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	var d deleteRequest
+	var b bytes.Buffer
+
+	if !readBody(res, req, &b) {
+		return
+	}
+
+	err := json.Unmarshal(b.Bytes(), &d)
+	if err != nil {
+		msg := "Error unmarshal"
+		log.Debug(msg, zap.Error(err))
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: msg})
+		return
+	}
+
+	if d.Pattern == "" {
+		msg := "Empty pattern"
+		log.Debug(msg)
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: msg, Field: "pattern"})
+		return
+	}
+
+	switch d.MType {
+	case "", storage.CounterType, storage.GaugeType:
+	default:
+		msg := fmt.Sprintf("Bad metric's type: %s", d.MType)
+		log.Debug(msg)
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidType, Message: msg, Field: "type"})
+		return
+	}
+
+	if !auth.AllowedPattern(req.Context(), d.Pattern) {
+		msg := fmt.Sprintf("Forbidden pattern: %s", d.Pattern)
+		log.Debug(msg)
+		jsonError(res, http.StatusForbidden, APIError{Code: CodeForbidden, Message: msg, Field: "pattern"})
+		return
+	}
+
+	deleted, err := s.DeleteMatched(d.MType, d.Pattern)
+	if err != nil {
+		msg := fmt.Sprintf("Bad pattern: %s", d.Pattern)
+		log.Debug(msg, zap.Error(err))
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: msg, Field: "pattern"})
+		return
+	}
+	removed := make([]storage.Metrics, 0, len(deleted))
+	for _, m := range deleted {
+		removed = append(removed, stream.Removed(m.MType, m.ID))
+	}
+	hub.PublishAll(removed)
+
+	log.Debug("Metrics deleted", zap.String("pattern", d.Pattern), zap.Int("count", len(deleted)))
+	audit(req, "delete", zap.String("pattern", d.Pattern), zap.Int("count", len(deleted)))
+
+	resJSON, err := json.Marshal(deleted)
+	if err != nil {
+		msg := "Error marshal"
+		log.Debug(msg, zap.Error(err))
+		jsonError(res, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: msg})
 		return
 	}
 
@@ -260,3 +467,28 @@ func ValueJSONHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 }
+
+func ResetHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	metricName := chi.URLParam(req, "metric-name")
+
+	if !allowed(res, req, metricName, textError) {
+		return
+	}
+
+	if !s.ResetCounter(metricName) {
+		msg := "Not found"
+		log.Debug(msg, zap.String("name", metricName))
+		http.Error(res, msg, http.StatusNotFound)
+		return
+	}
+	// Ряды агентов сброшены, остаётся общий нулевой счётчик
+	if m, err := s.GetMetric(storage.CounterType, metricName); err == nil {
+		hub.PublishAll([]storage.Metrics{stream.Removed(storage.CounterType, metricName), m})
+	}
+
+	log.Debug("Counter reset", zap.String("name", metricName))
+	audit(req, "reset", zap.String("name", metricName))
+	res.WriteHeader(http.StatusOK)
+}
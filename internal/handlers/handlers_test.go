@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -188,3 +192,175 @@ func TestMetricsHandler(t *testing.T) {
 	}
 
 }
+
+func TestDeleteHandlers(t *testing.T) {
+	type testType struct {
+		name         string
+		method       string
+		requestURL   string
+		body         string
+		expectedCode int
+		expectedBody string
+	}
+
+	tests := []testType{
+		{
+			name:         "Delete Gauge",
+			method:       http.MethodDelete,
+			requestURL:   "/value/gauge/delGauge",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Delete missing Gauge",
+			method:       http.MethodDelete,
+			requestURL:   "/value/gauge/delGauge",
+			expectedCode: http.StatusNotFound,
+			expectedBody: "Not found\n",
+		},
+		{
+			name:         "Delete with bad type",
+			method:       http.MethodDelete,
+			requestURL:   "/value/unknown/delGauge",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "Bad metric's type\n",
+		},
+		{
+			name:         "Reset Counter",
+			method:       http.MethodPost,
+			requestURL:   "/reset/delCounter",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Reset missing Counter",
+			method:       http.MethodPost,
+			requestURL:   "/reset/missingCounter",
+			expectedCode: http.StatusNotFound,
+			expectedBody: "Not found\n",
+		},
+		{
+			name:         "Bulk delete by pattern",
+			method:       http.MethodPost,
+			requestURL:   "/delete/",
+			body:         `{"pattern":"del*","type":"counter"}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"id":"delCounter","type":"counter","delta":0}]`,
+		},
+		{
+			name:         "Bulk delete with bad pattern",
+			method:       http.MethodPost,
+			requestURL:   "/delete/",
+			body:         `{"pattern":"del["}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":{"code":"bad_request","message":"Bad pattern: del[","field":"pattern"}}`,
+		},
+		{
+			name:         "Bulk delete without pattern",
+			method:       http.MethodPost,
+			requestURL:   "/delete/",
+			body:         `{"type":"gauge"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":{"code":"bad_request","message":"Empty pattern","field":"pattern"}}`,
+		},
+	}
+
+	s.UpdateGauge("delGauge", 1)
+	s.IncrementCounter("delCounter", 7)
+
+	sub := hub.Subscribe(stream.Filter{Name: "del*"})
+	defer hub.Unsubscribe(sub)
+
+	r := chi.NewRouter()
+	r.Delete("/value/{metric-type}/{metric-name}", DeleteHandler)
+	r.Post("/delete/", DeleteJSONHandler)
+	r.Post("/reset/{metric-name}", ResetHandler)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, ts.URL+test.requestURL, strings.NewReader(test.body))
+			require.NoError(t, err)
+
+			res, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expectedCode, res.StatusCode)
+			assert.Equal(t, test.expectedBody, string(body))
+		})
+	}
+
+	// Подписчики узнают об удалении метрик, сброс заменяет ряды агентов
+	// общим нулевым счётчиком
+	require.Len(t, sub.C, 4)
+	assert.Equal(t, stream.Removed(storage.GaugeType, "delGauge"), <-sub.C)
+	assert.Equal(t, stream.Removed(storage.CounterType, "delCounter"), <-sub.C)
+	m := <-sub.C
+	assert.Equal(t, "delCounter", m.ID)
+	assert.Equal(t, int64(0), *m.Delta)
+	assert.Equal(t, stream.Removed(storage.CounterType, "delCounter"), <-sub.C)
+}
+
+func TestJSONErrors(t *testing.T) {
+	type testType struct {
+		name         string
+		requestURL   string
+		body         string
+		expectedCode int
+		expected     APIError
+	}
+
+	tests := []testType{
+		{
+			name:         "Update with bad JSON",
+			requestURL:   "/update/",
+			body:         `{"id":`,
+			expectedCode: http.StatusBadRequest,
+			expected:     APIError{Code: CodeInvalidJSON, Message: "Error unmarshal"},
+		},
+		{
+			name:         "Update with bad type",
+			requestURL:   "/update/",
+			body:         `{"id":"errGauge","type":"histogram","value":1}`,
+			expectedCode: http.StatusBadRequest,
+			expected:     APIError{Code: CodeInvalidType, Message: "Bad metric's type: histogram", Field: "type"},
+		},
+		{
+			name:         "Update Counter without delta",
+			requestURL:   "/update/",
+			body:         `{"id":"errCounter","type":"counter"}`,
+			expectedCode: http.StatusBadRequest,
+			expected:     APIError{Code: CodeInvalidValue, Message: "Bad Counter's value: errCounter", Field: "delta"},
+		},
+		{
+			name:         "Value not found",
+			requestURL:   "/value/",
+			body:         `{"id":"errMissing","type":"gauge"}`,
+			expectedCode: http.StatusNotFound,
+			expected:     APIError{Code: CodeNotFound, Message: "Not found", Field: "id"},
+		},
+	}
+
+	r := chi.NewRouter()
+	r.Post("/update/", UpdateJSONHandler)
+	r.Post("/value/", ValueJSONHandler)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.requestURL, strings.NewReader(test.body))
+			res := httptest.NewRecorder()
+
+			r.ServeHTTP(res, req)
+			assert.Equal(t, test.expectedCode, res.Code)
+			assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+
+			var e ErrorResponse
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &e))
+			assert.Equal(t, test.expected, e.Error)
+		})
+	}
+}
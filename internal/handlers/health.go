@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const pingTimeout = 2 * time.Second
+
+// Health - состояние сервера для проб оркестратора.
+type Health struct {
+	restored     atomic.Bool
+	shuttingDown atomic.Bool
+	// ping проверяет доступность хранилища, nil - проверять нечего
+	ping func(context.Context) error
+}
+
+func NewHealth(ping func(context.Context) error) *Health {
+	return &Health{ping: ping}
+}
+
+// SetRestored отмечает, что хранилище восстановлено и сервер может
+// принимать запросы.
+func (h *Health) SetRestored() {
+	h.restored.Store(true)
+}
+
+// ShuttingDown переводит /readyz в 503 перед остановкой сервера.
+func (h *Health) ShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+func (h *Health) checkBackend(ctx context.Context) error {
+	if h.ping == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return h.ping(ctx)
+}
+
+type healthStatus struct {
+	Status       string `json:"status"`
+	Restored     *bool  `json:"restored,omitempty"`
+	ShuttingDown *bool  `json:"shutting_down,omitempty"`
+	Backend      string `json:"backend,omitempty"`
+}
+
+func writeHealth(res http.ResponseWriter, status int, body healthStatus) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(body)
+}
+
+// LiveHandler отвечает 200, пока процесс жив.
+func (h *Health) LiveHandler(res http.ResponseWriter, _ *http.Request) {
+	writeHealth(res, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// ReadyHandler отвечает 200, когда хранилище восстановлено, доступно и
+// сервер не останавливается, иначе 503 с причиной.
+func (h *Health) ReadyHandler(res http.ResponseWriter, req *http.Request) {
+	restored, shuttingDown := h.restored.Load(), h.shuttingDown.Load()
+	body := healthStatus{Status: "ok", Restored: &restored, ShuttingDown: &shuttingDown, Backend: "ok"}
+
+	if err := h.checkBackend(req.Context()); err != nil {
+		body.Backend = err.Error()
+		body.Status = "unavailable"
+	}
+	if !restored || shuttingDown {
+		body.Status = "unavailable"
+	}
+
+	status := http.StatusOK
+	if body.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(res, status, body)
+}
+
+// PingHandler проверяет только доступность хранилища: 500, если оно
+// недоступно.
+func (h *Health) PingHandler(res http.ResponseWriter, req *http.Request) {
+	if err := h.checkBackend(req.Context()); err != nil {
+		writeHealth(res, http.StatusInternalServerError, healthStatus{Status: "unavailable", Backend: err.Error()})
+		return
+	}
+	writeHealth(res, http.StatusOK, healthStatus{Status: "ok", Backend: "ok"})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	var backend error
+	h := NewHealth(func(context.Context) error { return backend })
+
+	serve := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		return res
+	}
+
+	assert.Equal(t, http.StatusOK, serve(h.LiveHandler).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(h.ReadyHandler).Code, "not restored yet")
+
+	h.SetRestored()
+	res := serve(h.ReadyHandler)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"status":"ok","restored":true,"shutting_down":false,"backend":"ok"}`, res.Body.String())
+	assert.Equal(t, http.StatusOK, serve(h.PingHandler).Code)
+
+	backend = errors.New("disk is gone")
+	assert.Equal(t, http.StatusServiceUnavailable, serve(h.ReadyHandler).Code)
+	res = serve(h.PingHandler)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Contains(t, res.Body.String(), "disk is gone")
+
+	backend = nil
+	h.ShuttingDown()
+	assert.Equal(t, http.StatusServiceUnavailable, serve(h.ReadyHandler).Code)
+	assert.Equal(t, http.StatusOK, serve(h.LiveHandler).Code)
+}
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/query"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+const (
+	queryLimitDef = 100
+	queryLimitMax = 1000
+
+	sortByName  = "name"
+	sortByType  = "type"
+	sortByValue = "value"
+)
+
+type aggregateResponse struct {
+	Result []query.Result `json:"result"`
+}
+
+type queryResponse struct {
+	Metrics    []storage.Metrics `json:"metrics"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// queryCursor - позиция последней отданной метрики. Следующая страница
+// начинается строго после неё, поэтому добавление и удаление метрик
+// между запросами не сдвигает уже отданные элементы.
+type queryCursor struct {
+	Sort  string  `json:"s"`
+	Value float64 `json:"v,omitempty"`
+	MType string  `json:"t"`
+	ID    string  `json:"i"`
+}
+
+type metricsQuery struct {
+	mType  string
+	prefix string
+	labels map[string]string
+	sortBy string
+	desc   bool
+	limit  int
+	cursor *queryCursor
+}
+
+func parseMetricsQuery(req *http.Request) (*metricsQuery, error) {
+	v := req.URL.Query()
+	q := &metricsQuery{
+		mType:  v.Get("type"),
+		prefix: v.Get("prefix"),
+		sortBy: sortByName,
+		limit:  queryLimitDef,
+	}
+
+	switch q.mType {
+	case "", storage.CounterType, storage.GaugeType:
+	default:
+		return nil, &APIError{Code: CodeInvalidType, Message: fmt.Sprintf("bad metric's type: %s", q.mType), Field: "type"}
+	}
+
+	labels, err := parseLabels(v["label"])
+	if err != nil {
+		return nil, err
+	}
+	q.labels = labels
+
+	if sortParam := v.Get("sort"); sortParam != "" {
+		q.desc = strings.HasPrefix(sortParam, "-")
+		q.sortBy = strings.TrimPrefix(sortParam, "-")
+
+		switch q.sortBy {
+		case sortByName, sortByType, sortByValue:
+		default:
+			return nil, &APIError{Code: CodeBadRequest, Message: fmt.Sprintf("bad sort: %s", sortParam), Field: "sort"}
+		}
+	}
+
+	if limitParam := v.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 || limit > queryLimitMax {
+			return nil, &APIError{Code: CodeBadRequest, Message: fmt.Sprintf("bad limit: %s", limitParam), Field: "limit"}
+		}
+		q.limit = limit
+	}
+
+	if cursorParam := v.Get("cursor"); cursorParam != "" {
+		c, err := decodeCursor(cursorParam)
+		if err != nil || c.Sort != sortKey(q.sortBy, q.desc) {
+			return nil, &APIError{Code: CodeBadRequest, Message: "bad cursor", Field: "cursor"}
+		}
+		q.cursor = c
+	}
+
+	return q, nil
+}
+
+// parseLabels разбирает параметры label=k=v. Из меток есть только agent,
+// пустое значение отбирает ряды без агента.
+func parseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(values))
+	for _, l := range values {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, &APIError{Code: CodeBadRequest, Message: fmt.Sprintf("bad label: %s", l), Field: "label"}
+		}
+		if k != storage.LabelAgent {
+			return nil, &APIError{Code: CodeBadRequest, Message: fmt.Sprintf("unsupported label: %s", k), Field: "label"}
+		}
+		if prev, ok := labels[k]; ok && prev != v {
+			return nil, &APIError{Code: CodeBadRequest, Message: fmt.Sprintf("conflicting label: %s", k), Field: "label"}
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+func sortKey(sortBy string, desc bool) string {
+	if desc {
+		return "-" + sortBy
+	}
+	return sortBy
+}
+
+func encodeCursor(c queryCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*queryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var c queryCursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func metricValue(m storage.Metrics) float64 {
+	if m.Delta != nil {
+		return float64(*m.Delta)
+	}
+	if m.Value != nil {
+		return *m.Value
+	}
+	return 0
+}
+
+func cursorOf(q *metricsQuery, m storage.Metrics) queryCursor {
+	c := queryCursor{Sort: sortKey(q.sortBy, q.desc), MType: m.MType, ID: m.ID}
+	if q.sortBy == sortByValue {
+		c.Value = metricValue(m)
+	}
+	return c
+}
+
+// less задаёт полный порядок: поле сортировки, затем тип и имя,
+// чтобы курсор однозначно указывал на позицию.
+func (q *metricsQuery) less(a, b queryCursor) bool {
+	switch q.sortBy {
+	case sortByValue:
+		if a.Value != b.Value {
+			return (a.Value < b.Value) != q.desc
+		}
+	case sortByName:
+		if a.ID != b.ID {
+			return (a.ID < b.ID) != q.desc
+		}
+	}
+
+	if a.MType != b.MType {
+		return (a.MType < b.MType) != q.desc
+	}
+	if a.ID != b.ID {
+		return (a.ID < b.ID) != q.desc
+	}
+	return false
+}
+
+func (q *metricsQuery) apply(all []storage.Metrics) ([]storage.Metrics, string) {
+	filtered := make([]storage.Metrics, 0, len(all))
+	for _, m := range all {
+		if q.mType != "" && m.MType != q.mType {
+			continue
+		}
+		if !strings.HasPrefix(m.ID, q.prefix) {
+			continue
+		}
+		if !query.MatchLabels(q.labels, m) {
+			continue
+		}
+		if q.cursor != nil && !q.less(*q.cursor, cursorOf(q, m)) {
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		return q.less(cursorOf(q, filtered[i]), cursorOf(q, filtered[j]))
+	})
+
+	if len(filtered) <= q.limit {
+		return filtered, ""
+	}
+
+	page := filtered[:q.limit]
+	return page, encodeCursor(cursorOf(q, page[len(page)-1]))
+}
+
+func QueryHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	q, err := parseMetricsQuery(req)
+	if err != nil {
+		log.Debug("Bad query", zap.Error(err))
+		badRequest(res, err)
+		return
+	}
+
+	// С метками отдаются ряды агентов, метка agent однозначно задаёт ряд
+	all := s.All()
+	if q.labels != nil {
+		all = s.Series()
+	}
+	page, next := q.apply(all)
+
+	resJSON, err := json.Marshal(queryResponse{Metrics: page, NextCursor: next})
+	if err != nil {
+		msg := "Error marshal"
+		log.Debug(msg, zap.Error(err))
+		jsonError(res, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(resJSON)
+	if err != nil {
+		return
+	}
+}
+
+func AggregateHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	var q query.Query
+	var b bytes.Buffer
+
+	if !readBody(res, req, &b) {
+		return
+	}
+
+	err := json.Unmarshal(b.Bytes(), &q)
+	if err != nil {
+		msg := "Error unmarshal"
+		log.Debug(msg, zap.Error(err))
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidJSON, Message: msg})
+		return
+	}
+
+	result, err := query.Evaluate(s, q, time.Now())
+	if err != nil {
+		log.Debug("Bad query", zap.Error(err))
+		badRequest(res, err)
+		return
+	}
+
+	resJSON, err := json.Marshal(aggregateResponse{Result: result})
+	if err != nil {
+		msg := "Error marshal"
+		log.Debug(msg, zap.Error(err))
+		jsonError(res, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: msg})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(resJSON)
+	if err != nil {
+		return
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/storage/storagetest"
+)
+
+func queryTestMetrics() []storage.Metrics {
+	gauge, counter := storagetest.Gauge, storagetest.Counter
+
+	return []storage.Metrics{
+		gauge("HeapAlloc", 30),
+		gauge("HeapSys", 10),
+		counter("PollCount", 20),
+		gauge("Alloc", 20),
+		counter("HeapCount", 5),
+	}
+}
+
+// queryTestSeries - ряды агентов, как их отдаёт storage.Series.
+func queryTestSeries() []storage.Metrics {
+	on, gauge := storagetest.On, storagetest.Gauge
+
+	return append(queryTestMetrics(), on("host1", gauge("Goroutines", 7)), on("host2", gauge("Goroutines", 9)))
+}
+
+func ids(metrics []storage.Metrics) []string {
+	res := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		res = append(res, m.MType+"/"+m.ID)
+	}
+	return res
+}
+
+func TestMetricsQuery(t *testing.T) {
+	type testType struct {
+		name     string
+		url      string
+		expected []string
+		wantErr  bool
+	}
+
+	tests := []testType{
+		{
+			name:     "Default sort by name",
+			url:      "/api/v1/metrics",
+			expected: []string{"gauge/Alloc", "gauge/HeapAlloc", "counter/HeapCount", "gauge/HeapSys", "counter/PollCount"},
+		},
+		{
+			name:     "Filter by type and prefix",
+			url:      "/api/v1/metrics?type=gauge&prefix=Heap",
+			expected: []string{"gauge/HeapAlloc", "gauge/HeapSys"},
+		},
+		{
+			name:     "Sort by value desc with ties",
+			url:      "/api/v1/metrics?sort=-value",
+			expected: []string{"gauge/HeapAlloc", "gauge/Alloc", "counter/PollCount", "gauge/HeapSys", "counter/HeapCount"},
+		},
+		{
+			name:     "Sort by type",
+			url:      "/api/v1/metrics?sort=type",
+			expected: []string{"counter/HeapCount", "counter/PollCount", "gauge/Alloc", "gauge/HeapAlloc", "gauge/HeapSys"},
+		},
+		{
+			name:    "Bad sort",
+			url:     "/api/v1/metrics?sort=size",
+			wantErr: true,
+		},
+		{
+			name:    "Bad limit",
+			url:     "/api/v1/metrics?limit=0",
+			wantErr: true,
+		},
+		{
+			name:     "Filter by agent",
+			url:      "/api/v1/metrics?label=agent=host2",
+			expected: []string{"gauge/Goroutines"},
+		},
+		{
+			name:     "Without agent",
+			url:      "/api/v1/metrics?label=agent=&prefix=Heap",
+			expected: []string{"gauge/HeapAlloc", "counter/HeapCount", "gauge/HeapSys"},
+		},
+		{
+			name:    "Unknown label",
+			url:     "/api/v1/metrics?label=host=a",
+			wantErr: true,
+		},
+		{
+			name:    "Bad label",
+			url:     "/api/v1/metrics?label=agent",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := parseMetricsQuery(httptest.NewRequest("GET", test.url, nil))
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			all := queryTestMetrics()
+			if q.labels != nil {
+				all = queryTestSeries()
+			}
+			page, next := q.apply(all)
+			assert.Equal(t, test.expected, ids(page))
+			assert.Empty(t, next)
+		})
+	}
+}
+
+func TestMetricsQueryPagination(t *testing.T) {
+	var got []string
+	url := "/api/v1/metrics?sort=-value&limit=2"
+	all := queryTestMetrics()
+
+	for i := 0; i < 10; i++ {
+		q, err := parseMetricsQuery(httptest.NewRequest("GET", url, nil))
+		require.NoError(t, err)
+
+		page, next := q.apply(all)
+		got = append(got, ids(page)...)
+		if next == "" {
+			break
+		}
+		url = "/api/v1/metrics?sort=-value&limit=2&cursor=" + next
+
+		// Новая метрика в начале порядка не должна сдвигать страницы
+		if i == 0 {
+			v := float64(100)
+			all = append(all, storage.Metrics{ID: "Added", MType: storage.GaugeType, Value: &v})
+		}
+	}
+
+	assert.Equal(t, []string{"gauge/HeapAlloc", "gauge/Alloc", "counter/PollCount", "gauge/HeapSys", "counter/HeapCount"}, got)
+
+	_, err := parseMetricsQuery(httptest.NewRequest("GET", "/api/v1/metrics?sort=name&cursor="+encodeCursor(queryCursor{Sort: "-value"}), nil))
+	assert.Error(t, err, "cursor from another sort must be rejected")
+}
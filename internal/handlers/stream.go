@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
+)
+
+const streamHeartbeat = 15 * time.Second
+
+var hub = stream.GetHub()
+
+// publish рассылает подписчикам текущее значение ряда агента после
+// обновления.
+func publish(mType, name, agent string) {
+	m, err := s.GetSeries(mType, name, agent)
+	if err != nil {
+		return
+	}
+
+	hub.Publish(m)
+}
+
+// resyncMetrics возвращает текущие значения рядов, проходящих фильтр.
+func resyncMetrics(f stream.Filter) []storage.Metrics {
+	metrics := make([]storage.Metrics, 0)
+	for _, m := range s.Series() {
+		if f.Match(m) {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// StreamHandler отдаёт обновления рядов метрик как Server-Sent Events,
+// у ряда агента есть метка agent. Параметры name (glob), type и
+// label=agent=<id> ограничивают поток. Удаление метрики
+// приходит событием delete с id и type. После restore и
+// import приходит событие resync со всеми подходящими метриками: оно
+// заменяет то, что клиент знал до него.
+func StreamHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	f := stream.Filter{
+		Name:  req.URL.Query().Get("name"),
+		MType: req.URL.Query().Get("type"),
+	}
+
+	switch f.MType {
+	case "", storage.CounterType, storage.GaugeType:
+	default:
+		msg := fmt.Sprintf("Bad metric's type: %s", f.MType)
+		log.Debug(msg)
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeInvalidType, Message: msg, Field: "type"})
+		return
+	}
+
+	if _, err := path.Match(f.Name, ""); err != nil {
+		msg := fmt.Sprintf("Bad name: %s", f.Name)
+		log.Debug(msg, zap.Error(err))
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: msg, Field: "name"})
+		return
+	}
+
+	labels, err := parseLabels(req.URL.Query()["label"])
+	if err != nil {
+		log.Debug("Bad label", zap.Error(err))
+		badRequest(res, err)
+		return
+	}
+	f.Labels = labels
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		msg := "Streaming unsupported"
+		log.Error(msg)
+		jsonError(res, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: msg})
+		return
+	}
+
+	sub := hub.Subscribe(f)
+	defer hub.Unsubscribe(sub)
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-hub.Done():
+			// http.Server.Shutdown не отменяет контекст запроса
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-sub.Resync:
+			data, err := json.Marshal(resyncMetrics(f))
+			if err != nil {
+				log.Debug("Error marshal", zap.Error(err))
+				continue
+			}
+
+			if _, err = fmt.Fprintf(res, "event: resync\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		case m, ok := <-sub.C:
+			if !ok {
+				log.Debug("Slow stream subscriber dropped", zap.String("remote", req.RemoteAddr))
+				return
+			}
+
+			data, err := json.Marshal(m)
+			if err != nil {
+				log.Debug("Error marshal", zap.Error(err))
+				continue
+			}
+
+			event := "metric"
+			if stream.IsRemoved(m) {
+				event = "delete"
+			}
+			if _, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/pavelborisofff/go-metrics/internal/gzip"
+	"github.com/pavelborisofff/go-metrics/internal/limit"
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/selfmetrics"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+const (
+	CodeInvalidImport = "invalid_import"
+	ndjsonType        = "application/x-ndjson"
+)
+
+// ExportHandler выгружает хранилище без служебных метрик построчно в
+// NDJSON. С ?compress= ответ сжимается указанным кодеком независимо от
+// Accept-Encoding, чтобы выгрузку можно было сохранить файлом и потом
+// отправить в /admin/import с тем же Content-Encoding.
+func ExportHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	var codec *gzip.Codec
+	if name := req.URL.Query().Get("compress"); name != "" {
+		c, ok := gzip.Lookup(name)
+		if !ok {
+			jsonError(res, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "Unknown codec: " + name, Field: "compress"})
+			return
+		}
+		codec = c
+	}
+
+	res.Header().Set("Content-Type", ndjsonType)
+	res.Header().Set("Content-Disposition", `attachment; filename="metrics.jsonl"`)
+
+	if codec == nil {
+		res.WriteHeader(http.StatusOK)
+		if _, err := s.Export(res); err != nil {
+			log.Debug("Error exporting metrics", zap.Error(err))
+		}
+		return
+	}
+
+	zw, err := codec.NewWriter(res)
+	if err != nil {
+		log.Error("Error creating compressor", zap.String("codec", codec.Name), zap.Error(err))
+		jsonError(res, http.StatusInternalServerError, APIError{Code: CodeInternal, Message: "Error creating compressor"})
+		return
+	}
+	res.Header().Set("Content-Encoding", codec.Name)
+	res.WriteHeader(http.StatusOK)
+
+	if _, err = s.Export(zw); err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Debug("Error exporting metrics", zap.Error(err))
+	}
+}
+
+// ImportHandler загружает NDJSON-выгрузку: ?mode=merge (по умолчанию)
+// или replace, ?dry_run=true только считает изменения. Сжатое тело
+// распаковывает GzipHandle по Content-Encoding. Выгрузка проверяется
+// целиком до записи, ошибка указывает номер строки. Служебные метрики
+// selfmetrics.Prefix пропускаются и при replace не удаляются. После записи
+// подписчики /stream и дашборда получают resync.
+func ImportHandler(res http.ResponseWriter, req *http.Request) {
+	log := logger.FromContext(req.Context())
+
+	q := req.URL.Query()
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = storage.ImportMerge
+	}
+	if mode != storage.ImportMerge && mode != storage.ImportReplace {
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "Mode must be merge or replace", Field: "mode"})
+		return
+	}
+
+	var dryRun bool
+	if v := q.Get("dry_run"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			jsonError(res, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: "Bad dry_run value", Field: "dry_run"})
+			return
+		}
+		dryRun = b
+	}
+
+	metrics, err := storage.ReadMetricsLines(req.Body)
+	if err != nil {
+		log.Debug("Error reading import", zap.Error(err))
+		if limit.TooLarge(err) {
+			jsonError(res, http.StatusRequestEntityTooLarge, APIError{Code: CodeTooLarge, Message: "Request body too large"})
+			return
+		}
+		e := APIError{Code: CodeInvalidImport, Message: err.Error()}
+		var le *storage.LineError
+		if errors.As(err, &le) {
+			e.Field = "line " + strconv.Itoa(le.Line)
+		}
+		jsonError(res, http.StatusBadRequest, e)
+		return
+	}
+
+	kept := metrics[:0]
+	skipped := 0
+	for _, m := range metrics {
+		if strings.HasPrefix(m.ID, selfmetrics.Prefix) {
+			skipped++
+			continue
+		}
+		kept = append(kept, m)
+	}
+
+	rep, err := s.Import(kept, mode, dryRun)
+	if err != nil {
+		jsonError(res, http.StatusBadRequest, APIError{Code: CodeBadRequest, Message: err.Error(), Field: "mode"})
+		return
+	}
+	rep.Skipped = skipped
+
+	if !dryRun {
+		if rep.Added+rep.Updated+rep.Removed > 0 {
+			hub.Resync()
+		}
+		selfmetrics.Default.Ingested("import", rep.Added+rep.Updated)
+		audit(req, "import",
+			zap.String("mode", mode),
+			zap.Int("added", rep.Added),
+			zap.Int("updated", rep.Updated),
+			zap.Int("removed", rep.Removed),
+		)
+	}
+	writeJSON(res, http.StatusOK, rep)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pavelborisofff/go-metrics/internal/gzip"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
+)
+
+func TestExportImport(t *testing.T) {
+	// Хранилище общее для тестов пакета, после теста возвращаем его как было
+	backup := filepath.Join(t.TempDir(), "backup.json")
+	_, err := s.Save(context.Background(), backup)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Restore(context.Background(), backup) })
+
+	s.UpdateGauge("exportGauge", 1.5)
+	s.IncrementCounter("exportCounter", 7)
+	s.UpdateGauge("_self.exportHidden", 1)
+
+	res := httptest.NewRecorder()
+	ExportHandler(res, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, ndjsonType, res.Header().Get("Content-Type"))
+	dump := res.Body.String()
+	assert.Contains(t, dump, `{"id":"exportGauge","type":"gauge","value":1.5}`+"\n")
+	assert.Contains(t, dump, `{"id":"exportCounter","type":"counter","delta":7}`+"\n")
+	assert.NotContains(t, dump, "_self.")
+
+	res = httptest.NewRecorder()
+	ExportHandler(res, httptest.NewRequest(http.MethodGet, "/admin/export?compress=gzip", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "gzip", res.Header().Get("Content-Encoding"))
+	c, _ := gzip.Lookup("gzip")
+	zr, err := c.NewReader(res.Body)
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, dump, string(plain))
+
+	res = httptest.NewRecorder()
+	ExportHandler(res, httptest.NewRequest(http.MethodGet, "/admin/export?compress=lzma", nil))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	importReq := func(query, body string) (*httptest.ResponseRecorder, storage.ImportReport) {
+		res := httptest.NewRecorder()
+		ImportHandler(res, httptest.NewRequest(http.MethodPost, "/admin/import"+query, strings.NewReader(body)))
+		var rep storage.ImportReport
+		if res.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &rep))
+		}
+		return res, rep
+	}
+
+	s.UpdateGauge("exportGauge", 3)
+	s.UpdateGauge("exportExtra", 1)
+	body := dump + `{"id":"exportNew","type":"gauge","value":2}` + "\n" + `{"id":"_self.x","type":"gauge","value":1}`
+
+	// dry-run только считает
+	res, rep := importReq("?mode=replace&dry_run=true", body)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.True(t, rep.DryRun)
+	assert.Equal(t, 1, rep.Added)
+	assert.Equal(t, 1, rep.Updated)
+	assert.Positive(t, rep.Removed)
+	assert.Equal(t, 1, rep.Skipped)
+	_, ok := s.GetGauge("exportNew")
+	assert.False(t, ok)
+
+	// merge не трогает метрики, которых нет в выгрузке
+	res, rep = importReq("", body)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, storage.ImportMerge, rep.Mode)
+	assert.Zero(t, rep.Removed)
+	v, _ := s.GetGauge("exportGauge")
+	assert.Equal(t, storage.Gauge(1.5), v)
+	_, ok = s.GetGauge("exportExtra")
+	assert.True(t, ok)
+
+	// счётчик устанавливается, а не прибавляется; служебные метрики
+	// сервера replace не удаляет, изменения уходят подписчикам
+	s.UpdateGauge("_self.keep", 1)
+	s.IncrementCounter("exportCounter", 1)
+	sub := hub.Subscribe(stream.Filter{Name: "export*"})
+	defer hub.Unsubscribe(sub)
+
+	res, rep = importReq("?mode=replace", body)
+	require.Equal(t, http.StatusOK, res.Code)
+	cv, _ := s.GetCounter("exportCounter")
+	assert.Equal(t, storage.Counter(7), cv)
+	_, ok = s.GetGauge("exportExtra")
+	assert.False(t, ok)
+	_, ok = s.GetGauge("_self.x")
+	assert.False(t, ok)
+	_, ok = s.GetGauge("_self.keep")
+	assert.True(t, ok)
+
+	require.Equal(t, 1, rep.Updated)
+	select {
+	case <-sub.Resync:
+	default:
+		t.Fatal("import was not published")
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		field string
+	}{
+		{"Bad mode", "?mode=append", "", "mode"},
+		{"Bad dry_run", "?dry_run=maybe", "", "dry_run"},
+		{"Bad JSON", "", `{"id":"a","type":"gauge","value":1}` + "\n{", "line 2"},
+		{"No value", "", "\n" + `{"id":"a","type":"gauge"}`, "line 2"},
+		{"Bad type", "", `{"id":"a","type":"hist","value":1}`, "line 1"},
+		{"Negative counter", "", `{"id":"a","type":"counter","delta":-5}`, "line 1"},
+		{"Duplicate", "", `{"id":"a","type":"gauge","value":1}` + "\n" + `{"id":"a","type":"gauge","value":2}`, "line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, _ := importReq(tt.query, tt.body)
+			require.Equal(t, http.StatusBadRequest, res.Code)
+			var e ErrorResponse
+			require.NoError(t, json.NewDecoder(bytes.NewReader(res.Body.Bytes())).Decode(&e))
+			assert.Equal(t, tt.field, e.Error.Field)
+		})
+	}
+}
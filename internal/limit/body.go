@@ -0,0 +1,33 @@
+package limit
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Body ограничивает тело запроса max байтами, 0 - без ограничения.
+// Чтение сверх лимита вернёт *http.MaxBytesError, обработчик отвечает 413.
+// Размер после распаковки ограничивает gzip.GzipHandle.
+func Body(max int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if max <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > max {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TooLarge - ошибка чтения из-за превышения лимита Body.
+func TooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
@@ -0,0 +1,213 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+)
+
+// AgentHeader - идентификатор агента, заданный им самим.
+const (
+	AgentHeader      = "X-Agent-ID"
+	AgentMetadataKey = "x-agent-id"
+)
+
+// Key - чем различаются клиенты лимитера.
+type Key string
+
+const (
+	KeyIP    Key = "ip"
+	KeyToken Key = "token"
+	KeyAgent Key = "agent"
+)
+
+// idleTTL - через сколько простоя корзина клиента удаляется.
+const idleTTL = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter - token bucket на каждого клиента: rate запросов в секунду
+// с запасом burst.
+type Limiter struct {
+	rate  float64
+	burst float64
+	key   Key
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+func ParseKey(s string) (Key, error) {
+	switch k := Key(s); k {
+	case KeyIP, KeyToken, KeyAgent:
+		return k, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit key: %s", s)
+	}
+}
+
+// NewLimiter возвращает nil при rate <= 0: ограничение выключено.
+func NewLimiter(rate float64, burst int, key Key) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		key:     key,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// SetLimit меняет параметры на ходу. При смене ключа клиенты
+// начинают с полных корзин.
+func (l *Limiter) SetLimit(rate float64, burst int, key Key) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if key != l.key {
+		l.buckets = make(map[string]*bucket)
+	}
+	l.rate, l.burst, l.key = rate, float64(burst), key
+}
+
+// Allow забирает токен клиента. Если токенов нет, возвращает время,
+// через которое появится следующий.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// sweep раз в idleTTL удаляет корзины простаивающих клиентов.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < idleTTL {
+		return
+	}
+	l.swept = now
+
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > idleTTL {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// clientKey выбирает идентификатор клиента, без токена или ID агента - IP.
+// ID агента задаёт сам клиент, поэтому KeyAgent - для доверенной сети.
+func (l *Limiter) clientKey(ctx context.Context, ip, agent string) string {
+	l.mu.Lock()
+	key := l.key
+	l.mu.Unlock()
+
+	switch key {
+	case KeyToken:
+		if t := auth.FromContext(ctx); t != nil {
+			return "token:" + t.Name
+		}
+	case KeyAgent:
+		if agent != "" {
+			return "agent:" + agent
+		}
+	}
+	return "ip:" + ip
+}
+
+// Handle отвечает 429 с Retry-After клиентам, исчерпавшим лимит.
+func Handle(l *Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := l.clientKey(r.Context(), hostOf(r.RemoteAddr), r.Header.Get(AgentHeader))
+
+			if ok, wait := l.Allow(client); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// UnaryInterceptor - то же для gRPC, отвечает ResourceExhausted.
+func UnaryInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if l == nil {
+			return handler(ctx, req)
+		}
+
+		var ip, agent string
+		if p, ok := peer.FromContext(ctx); ok {
+			ip = hostOf(p.Addr.String())
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(AgentMetadataKey); len(v) > 0 {
+				agent = v[0]
+			}
+		}
+
+		if ok, wait := l.Allow(l.clientKey(ctx, ip, agent)); !ok {
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %s", wait)
+		}
+		return handler(ctx, req)
+	}
+}
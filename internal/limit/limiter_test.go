@@ -0,0 +1,131 @@
+package limit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(2, 3, KeyIP)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok, "burst request %d", i)
+	}
+
+	ok, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Другой клиент не затронут
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(1, 1, KeyIP)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	now = now.Add(2 * idleTTL)
+	l.Allow("b")
+
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestNewLimiterDisabled(t *testing.T) {
+	assert.Nil(t, NewLimiter(0, 10, KeyIP))
+
+	res := httptest.NewRecorder()
+	Handle(nil)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).
+		ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestClientKey(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), &auth.Token{Name: "agent"})
+
+	assert.Equal(t, "ip:10.0.0.1", NewLimiter(1, 1, KeyIP).clientKey(ctx, "10.0.0.1", "host"))
+	assert.Equal(t, "token:agent", NewLimiter(1, 1, KeyToken).clientKey(ctx, "10.0.0.1", "host"))
+	assert.Equal(t, "ip:10.0.0.1", NewLimiter(1, 1, KeyToken).clientKey(context.Background(), "10.0.0.1", "host"))
+	assert.Equal(t, "agent:host", NewLimiter(1, 1, KeyAgent).clientKey(ctx, "10.0.0.1", "host"))
+	assert.Equal(t, "ip:10.0.0.1", NewLimiter(1, 1, KeyAgent).clientKey(ctx, "10.0.0.1", ""))
+
+	_, err := ParseKey("cookie")
+	assert.Error(t, err)
+}
+
+func TestHandle(t *testing.T) {
+	h := Handle(NewLimiter(1, 1, KeyIP))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+}
+
+func TestBody(t *testing.T) {
+	var readErr error
+	h := Body(4)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	t.Run("Declared length", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too long")))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+	})
+
+	t.Run("Streamed body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too long"))
+		req.ContentLength = -1
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		require.Error(t, readErr)
+		assert.True(t, TooLarge(readErr))
+	})
+}
+
+func TestSetLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(1, 1, KeyIP)
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	// Новый burst действует на уже существующие корзины
+	l.SetLimit(10, 5, KeyIP)
+	now = now.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		ok, _ = l.Allow("a")
+		assert.True(t, ok, "request %d", i)
+	}
+
+	// Смена ключа сбрасывает корзины
+	l.SetLimit(10, 5, KeyToken)
+	assert.Empty(t, l.buckets)
+}
@@ -1,79 +1,286 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
 	instance = zap.NewNop()
-	once     sync.Once
+	// access - лог запросов, стек вызовов middleware в нём бесполезен
+	access = zap.NewNop()
+	once   sync.Once
+	// level можно менять на ходу, все логгеры из GetLogger его разделяют
+	level = zap.NewAtomicLevelAt(zap.DebugLevel)
+
+	// root - текущий приёмник логов, Configure его подменяет
+	root atomic.Pointer[sink]
+	mu   sync.Mutex
+
+	// sample - писать каждый sample-й успешный запрос
+	sample   atomic.Int64
+	requests atomic.Int64
 )
 
-type (
-	responseData struct {
-		status int
-		size   int
+// Options - настройки логгера.
+type Options struct {
+	// Level - debug, info, warn или error
+	Level string
+	// Format - console или json
+	Format string
+	// File - файл лога, пусто - stderr
+	File string
+	// MaxSize - размер файла в байтах, после которого он ротируется, 0 - без ротации
+	MaxSize int64
+	// MaxBackups - сколько ротированных файлов хранить
+	MaxBackups int
+	// Sample - писать в лог каждый n-й успешный запрос, 0 и 1 - все
+	Sample int
+}
+
+// sink - ядро zap и файл, в который оно пишет. Записи идут под RLock,
+// поэтому Configure закрывает файл старого приёмника только после того,
+// как закончатся начатые в него записи.
+type sink struct {
+	core   zapcore.Core
+	file   io.Closer
+	mu     sync.RWMutex
+	closed bool
+}
+
+// acquire возвращает текущий приёмник, захваченный на чтение.
+func acquire() *sink {
+	for {
+		sk := root.Load()
+		sk.mu.RLock()
+		if !sk.closed {
+			return sk
+		}
+		// Приёмник успели закрыть после Load, root уже указывает на новый
+		sk.mu.RUnlock()
 	}
+}
+
+// close дожидается записей в приёмник и закрывает его файл.
+func (sk *sink) close() {
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
 
-	loggingResponseWriter struct {
-		http.ResponseWriter
-		responseData *responseData
+	sk.core.Sync()
+	sk.closed = true
+	if sk.file != nil {
+		sk.file.Close()
 	}
-)
+}
 
-func (r *loggingResponseWriter) Write(b []byte) (int, error) {
-	size, err := r.ResponseWriter.Write(b)
-	r.responseData.size += size
-	return size, err
+func init() {
+	root.Store(&sink{core: newCore(zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), zapcore.Lock(os.Stderr))})
+}
+
+func newCore(enc zapcore.Encoder, ws zapcore.WriteSyncer) zapcore.Core {
+	return zapcore.NewCore(enc, ws, level)
+}
+
+// swapCore отдаёт записи текущему ядру из root, поэтому логгеры, полученные
+// до Configure, пишут уже по новым настройкам.
+type swapCore struct {
+	fields []zapcore.Field
+}
+
+func (c *swapCore) current(sk *sink) zapcore.Core {
+	if len(c.fields) > 0 {
+		return sk.core.With(c.fields)
+	}
+	return sk.core
 }
 
-func (r *loggingResponseWriter) WriteHeader(statusCode int) {
-	r.ResponseWriter.WriteHeader(statusCode)
-	r.responseData.status = statusCode
+func (c *swapCore) Enabled(l zapcore.Level) bool {
+	return level.Enabled(l)
+}
+
+func (c *swapCore) With(fields []zapcore.Field) zapcore.Core {
+	return &swapCore{fields: append(c.fields[:len(c.fields):len(c.fields)], fields...)}
+}
+
+// Check добавляет в запись сам swapCore, а не текущее ядро: ядро
+// выбирается в Write, когда приёмник захвачен.
+func (c *swapCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *swapCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	sk := acquire()
+	defer sk.mu.RUnlock()
+	return c.current(sk).Write(e, fields)
+}
+
+func (c *swapCore) Sync() error {
+	sk := acquire()
+	defer sk.mu.RUnlock()
+	return sk.core.Sync()
 }
 
 func GetLogger() *zap.Logger {
 	once.Do(func() {
-		logger, err := zap.NewDevelopment()
-		if err != nil {
-			panic(err)
-		}
-		instance = logger
+		instance = zap.New(&swapCore{}, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
+		access = zap.New(&swapCore{}, zap.AddCaller())
 	})
 
 	return instance
 }
 
-func LogHandle(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+// Configure применяет настройки ко всем логгерам из GetLogger. Его можно
+// вызывать повторно, например при перечитывании конфигурации. При ошибке
+// ничего, включая уровень, не меняется.
+func Configure(o Options) error {
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(o.Level)); err != nil {
+		return err
+	}
+
+	var enc zapcore.Encoder
+	switch o.Format {
+	case "", "console":
+		enc = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	case "json":
+		enc = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	default:
+		return fmt.Errorf("unknown log format %q", o.Format)
+	}
 
-		ResponseData := &responseData{
-			status: 0,
-			size:   0,
+	var ws zapcore.WriteSyncer = zapcore.Lock(os.Stderr)
+	var file io.Closer
+	if o.File != "" {
+		f, err := openRotating(o.File, o.MaxSize, o.MaxBackups)
+		if err != nil {
+			return err
 		}
+		ws, file = f, f
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	old := root.Swap(&sink{core: newCore(enc, ws), file: file})
+	old.close()
+	level.SetLevel(lvl)
+	sample.Store(int64(o.Sample))
+	requests.Store(0)
+
+	return nil
+}
+
+// SetLevel меняет уровень логирования: debug, info, warn, error.
+func SetLevel(l string) error {
+	return level.UnmarshalText([]byte(l))
+}
+
+// Level - текущий уровень логирования.
+func Level() string {
+	return level.String()
+}
+
+// LevelHandler показывает (GET) и меняет (PUT {"level":"info"}) уровень
+// логирования.
+func LevelHandler() http.Handler {
+	return level
+}
+
+// sampled решает, писать ли успешный запрос в лог.
+func sampled() bool {
+	n := sample.Load()
+	return n <= 1 || requests.Add(1)%n == 0
+}
 
-		lw := loggingResponseWriter{
-			ResponseWriter: w,
-			responseData:   ResponseData,
+// secretParams - параметры запроса, значения которых не пишутся в лог.
+var secretParams = []string{"access_token"}
+
+// redactURL возвращает адрес запроса с замаскированными secretParams.
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+
+	q := u.Query()
+	masked := false
+	for _, p := range secretParams {
+		if _, ok := q[p]; ok {
+			q.Set(p, "***")
+			masked = true
 		}
-		next.ServeHTTP(&lw, r)
+	}
+	if !masked {
+		return u.String()
+	}
+	return u.Path + "?" + q.Encode()
+}
+
+// WrapWriter возвращает обёртку chi над w, которая запоминает код и
+// размер ответа. Если w уже обёрнут выше по цепочке, возвращается он сам.
+func WrapWriter(w http.ResponseWriter, r *http.Request) middleware.WrapResponseWriter {
+	if ww, ok := w.(middleware.WrapResponseWriter); ok {
+		return ww
+	}
+	return middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+}
+
+// Status возвращает код ответа ww. Ответ без WriteHeader и Write - 200,
+// соединение, перехваченное под WebSocket, - 101.
+func Status(ww middleware.WrapResponseWriter, r *http.Request) int {
+	if status := ww.Status(); status != 0 {
+		return status
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return http.StatusSwitchingProtocols
+	}
+	return http.StatusOK
+}
+
+func LogHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := WrapWriter(w, r)
+		next.ServeHTTP(ww, r)
 
 		duration := time.Since(start)
+		status := Status(ww, r)
 
-		instance.Info("request",
+		fields := []zap.Field{
 			zap.String("method", r.Method),
-			zap.String("url", r.URL.String()),
-			zap.Int("status", ResponseData.status),
-			zap.Int("size", ResponseData.size),
+			zap.String("url", redactURL(r.URL)),
+			zap.Int("status", status),
+			zap.Int("size", ww.BytesWritten()),
 			zap.Duration("duration", duration),
 			zap.String("Content-Type", r.Header.Get("Content-Type")),
 			zap.String("Content-Encoding", r.Header.Get("Content-Encoding")),
 			zap.String("Accept-Encoding", r.Header.Get("Accept-Encoding")),
-		)
+		}
+		if id := RequestID(r.Context()); id != "" {
+			fields = append(fields, zap.String("request_id", id))
+		}
+
+		// Ошибки пишутся всегда, успешные запросы - выборочно
+		switch {
+		case status >= http.StatusInternalServerError:
+			access.Error("request", fields...)
+		case status >= http.StatusBadRequest:
+			access.Warn("request", fields...)
+		case sampled():
+			access.Info("request", fields...)
+		}
 	})
 }
@@ -0,0 +1,269 @@
+package logger
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// configure направляет логи в файл и возвращает функцию чтения этого файла.
+func configure(t *testing.T, o Options) func() []string {
+	o.File = filepath.Join(t.TempDir(), "test.log")
+	require.NoError(t, Configure(o))
+	t.Cleanup(func() { Configure(Options{Level: "debug"}) })
+
+	return func() []string {
+		data, err := os.ReadFile(o.File)
+		require.NoError(t, err)
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	// Логгер получен до Configure, как в пакетных переменных
+	log := GetLogger()
+	lines := configure(t, Options{Level: "info", Format: "json"})
+
+	log.Debug("hidden")
+	log.Info("shown")
+	log.With().Named("child").Warn("child")
+
+	got := lines()
+	require.Len(t, got, 2)
+	assert.Contains(t, got[0], `"msg":"shown"`)
+	assert.Contains(t, got[1], `"logger":"child"`)
+
+	assert.Error(t, Configure(Options{Level: "info", Format: "xml"}))
+	assert.Error(t, Configure(Options{Level: "loud"}))
+
+	// Неудачная настройка не меняет и уровень
+	assert.Error(t, Configure(Options{Level: "error", Format: "xml"}))
+	assert.Error(t, Configure(Options{Level: "error", File: filepath.Join(t.TempDir(), "missing", "dir", "x.log")}))
+	assert.Equal(t, "info", Level())
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rotate.log")
+	f, err := openRotating(path, 10, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, s := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = f.Write([]byte(s))
+		require.NoError(t, err)
+	}
+
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data), name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rotate.log")
+	// path.1 занят каталогом, переименовать в него файл нельзя
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "busy"), 0755))
+
+	f, err := openRotating(path, 10, 1)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, s := range []string{"first\n", "second\n"} {
+		_, err = f.Write([]byte(s))
+		require.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(data))
+}
+
+// Записи, начатые до Configure, не должны попадать в закрытый файл.
+func TestConfigureConcurrentWrites(t *testing.T) {
+	log := GetLogger()
+	dir := t.TempDir()
+	t.Cleanup(func() { Configure(Options{Level: "debug"}) })
+
+	var errs atomic.Int64
+	log = log.WithOptions(zap.ErrorOutput(zapcore.AddSync(writerFunc(func(p []byte) (int, error) {
+		errs.Add(1)
+		return len(p), nil
+	}))))
+
+	files := make([]string, 20)
+	for i := range files {
+		files[i] = filepath.Join(dir, fmt.Sprintf("%d.log", i))
+	}
+	require.NoError(t, Configure(Options{Level: "info", Format: "json", File: files[0]}))
+
+	const writers, writes = 4, 200
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				log.Info("line")
+			}
+		}()
+	}
+
+	for _, name := range files[1:] {
+		require.NoError(t, Configure(Options{Level: "info", Format: "json", File: name}))
+	}
+	wg.Wait()
+	require.NoError(t, Configure(Options{Level: "debug"}))
+
+	assert.Zero(t, errs.Load())
+	lines := 0
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		lines += strings.Count(string(data), "\n")
+	}
+	assert.Equal(t, writers*writes, lines)
+}
+
+// Configure ждёт записи, захватившей старый приёмник, и только потом
+// закрывает его файл.
+func TestConfigureWaitsForWriters(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { Configure(Options{Level: "debug"}) })
+	require.NoError(t, Configure(Options{Level: "info", Format: "json", File: filepath.Join(dir, "old.log")}))
+
+	sk := acquire()
+	done := make(chan struct{})
+	go func() {
+		Configure(Options{Level: "info", Format: "json", File: filepath.Join(dir, "new.log")})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("old sink closed during write")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, sk.core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "late"}, nil))
+	sk.mu.RUnlock()
+	<-done
+
+	data, err := os.ReadFile(filepath.Join(dir, "old.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"msg":"late"`)
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestLogHandleSample(t *testing.T) {
+	GetLogger()
+	lines := configure(t, Options{Level: "info", Format: "json", Sample: 3})
+
+	status := http.StatusOK
+	h := LogHandle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	for i := 0; i < 6; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	}
+	status = http.StatusInternalServerError
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	got := lines()
+	require.Len(t, got, 3)
+	assert.Contains(t, got[2], `"level":"error"`)
+}
+
+func TestLogHandleRedactsToken(t *testing.T) {
+	GetLogger()
+	lines := configure(t, Options{Level: "info", Format: "json"})
+
+	h := LogHandle(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ws?access_token=secret&x=1", nil))
+
+	got := lines()
+	require.Len(t, got, 1)
+	assert.NotContains(t, got[0], "secret")
+	assert.Contains(t, got[0], `"url":"/ws?access_token=%2A%2A%2A&x=1"`)
+}
+
+func TestLevelHandler(t *testing.T) {
+	t.Cleanup(func() { SetLevel("debug") })
+
+	res := httptest.NewRecorder()
+	LevelHandler().ServeHTTP(res, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"level":"warn"}`)))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "warn", Level())
+
+	res = httptest.NewRecorder()
+	LevelHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.JSONEq(t, `{"level":"warn"}`, res.Body.String())
+}
+
+func TestRequestIDHandle(t *testing.T) {
+	var got string
+	h := RequestIDHandle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+		FromContext(r.Context()).Info("handled")
+	}))
+
+	t.Run("Propagated", func(t *testing.T) {
+		lines := configure(t, Options{Level: "info", Format: "json"})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, "agent-1")
+		res := httptest.NewRecorder()
+
+		h.ServeHTTP(res, req)
+		assert.Equal(t, "agent-1", got)
+		assert.Equal(t, "agent-1", res.Header().Get(RequestIDHeader))
+		assert.Contains(t, lines()[0], `"request_id":"agent-1"`)
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		for _, id := range []string{"", "with space", strings.Repeat("x", 200)} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, id)
+			res := httptest.NewRecorder()
+
+			h.ServeHTTP(res, req)
+			assert.Len(t, got, 32)
+			assert.Equal(t, got, res.Header().Get(RequestIDHeader))
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	ww := WrapWriter(httptest.NewRecorder(), r)
+	assert.Same(t, ww, WrapWriter(ww, r))
+	assert.Equal(t, http.StatusOK, Status(ww, r))
+
+	ww.WriteHeader(http.StatusNotFound)
+	assert.Equal(t, http.StatusNotFound, Status(ww, r))
+
+	r.Header.Set("Upgrade", "websocket")
+	assert.Equal(t, http.StatusSwitchingProtocols, Status(WrapWriter(httptest.NewRecorder(), r), r))
+}
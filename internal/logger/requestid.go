@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// RequestIDHeader - заголовок с идентификатором запроса, сервер возвращает его в ответе
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDMetadataKey - то же для gRPC
+	RequestIDMetadataKey = "x-request-id"
+
+	maxRequestIDLen = 128
+)
+
+type requestIDKey struct{}
+
+type requestContext struct {
+	id  string
+	log *zap.Logger
+}
+
+// NewRequestID возвращает случайный идентификатор запроса.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID проверяет присланный клиентом идентификатор: непустой,
+// не длиннее 128 символов, только видимые ASCII-символы.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestID сохраняет идентификатор запроса и логгер с ним в контексте.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, &requestContext{
+		id:  id,
+		log: GetLogger().With(zap.String("request_id", id)),
+	})
+}
+
+// RequestID - идентификатор запроса из контекста, пусто если его нет.
+func RequestID(ctx context.Context) string {
+	if rc, ok := ctx.Value(requestIDKey{}).(*requestContext); ok {
+		return rc.id
+	}
+	return ""
+}
+
+// FromContext возвращает логгер, добавляющий к записям идентификатор запроса.
+func FromContext(ctx context.Context) *zap.Logger {
+	if rc, ok := ctx.Value(requestIDKey{}).(*requestContext); ok {
+		return rc.log
+	}
+	return GetLogger()
+}
+
+// RequestIDHandle берёт идентификатор из X-Request-ID или создаёт новый,
+// кладёт его в контекст и возвращает в ответе.
+func RequestIDHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !ValidRequestID(id) {
+			id = NewRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile - файл лога с ротацией по размеру: когда запись не помещается
+// в maxSize, файл переименовывается в path.1, старые копии сдвигаются до
+// path.<backups>, а запись продолжается в новый файл.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func openRotating(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open(mode int) error {
+	f, size, err := openFile(r.path, mode)
+	if err != nil {
+		return err
+	}
+	r.f, r.size = f, size
+	return nil
+}
+
+func openFile(path string, mode int) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|mode, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// Пишем в старый файл, ротация повторится на следующей записи
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate переключает запись на новый файл. Старый закрывается только
+// после того, как новый открыт, при ошибке запись продолжается в старый.
+func (r *rotatingFile) rotate() error {
+	if r.backups > 0 {
+		for i := r.backups - 1; i > 0; i-- {
+			err := os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	}
+
+	// Без копий файл просто начинается заново
+	f, size, err := openFile(r.path, os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	r.f.Close()
+	r.f, r.size = f, size
+	return nil
+}
+
+func (r *rotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Sync()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,285 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+const (
+	FuncSum   = "sum"
+	FuncAvg   = "avg"
+	FuncMin   = "min"
+	FuncMax   = "max"
+	FuncCount = "count"
+	FuncRate  = "rate"
+
+	GroupByName  = "name"
+	GroupByType  = "type"
+	GroupByAgent = storage.LabelAgent
+)
+
+var ErrRangeRequired = errors.New("rate requires range")
+
+// Query - агрегирующий запрос к хранилищу.
+//
+// Запрос считается по рядам storage.Reader.Series: метрика, которую пишут
+// несколько агентов, - это ряд на каждого агента, так что sum по HeapAlloc
+// складывает значения всех хостов. Без Range функция считается по текущим
+// значениям рядов. С Range - по всем значениям из истории за последний
+// интервал. rate всегда считается по истории для каждого ряда отдельно (в
+// секунду) и суммируется внутри группы.
+type Query struct {
+	Func    string            `json:"func"`               // sum, avg, min, max, count, rate
+	MType   string            `json:"type,omitempty"`     // gauge, counter или пусто для обоих типов
+	Match   string            `json:"match,omitempty"`    // glob-шаблон имени, пусто - все метрики
+	Labels  map[string]string `json:"labels,omitempty"`   // точное совпадение меток, есть только agent
+	GroupBy []string          `json:"group_by,omitempty"` // name, type, agent
+	Range   string            `json:"range,omitempty"`    // интервал истории, например 1m
+}
+
+type Result struct {
+	Group  map[string]string `json:"group"`
+	Value  float64           `json:"value"`
+	Series int               `json:"series"`
+}
+
+type group struct {
+	labels map[string]string
+	values []float64
+	series int
+}
+
+func (q *Query) validate() (time.Duration, error) {
+	switch q.Func {
+	case FuncSum, FuncAvg, FuncMin, FuncMax, FuncCount, FuncRate:
+	default:
+		return 0, fmt.Errorf("unknown func: %s", q.Func)
+	}
+
+	switch q.MType {
+	case "", storage.CounterType, storage.GaugeType:
+	default:
+		return 0, fmt.Errorf("bad metric's type: %s", q.MType)
+	}
+
+	if q.Match != "" {
+		if _, err := path.Match(q.Match, ""); err != nil {
+			return 0, fmt.Errorf("bad match: %s", q.Match)
+		}
+	}
+
+	for k := range q.Labels {
+		if k != storage.LabelAgent {
+			return 0, fmt.Errorf("unsupported label: %s", k)
+		}
+	}
+
+	for _, g := range q.GroupBy {
+		switch g {
+		case GroupByName, GroupByType, GroupByAgent:
+		default:
+			return 0, fmt.Errorf("unsupported group_by: %s", g)
+		}
+	}
+
+	var window time.Duration
+	if q.Range != "" {
+		d, err := time.ParseDuration(q.Range)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("bad range: %s", q.Range)
+		}
+		window = d
+	}
+
+	if q.Func == FuncRate && window == 0 {
+		return 0, ErrRangeRequired
+	}
+
+	return window, nil
+}
+
+// Evaluate выполняет запрос над r. now задаёт правую границу интервала истории.
+func Evaluate(r storage.Reader, q Query, now time.Time) ([]Result, error) {
+	window, err := q.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*group)
+
+	for _, m := range r.Series() {
+		if q.MType != "" && m.MType != q.MType {
+			continue
+		}
+		if q.Match != "" {
+			if ok, _ := path.Match(q.Match, m.ID); !ok {
+				continue
+			}
+		}
+		if !MatchLabels(q.Labels, m) {
+			continue
+		}
+
+		var values []float64
+		switch {
+		case q.Func == FuncRate:
+			rate, ok := seriesRate(m.MType, inWindow(r, m, now, window))
+			if !ok {
+				continue
+			}
+			values = []float64{rate}
+		case window > 0:
+			for _, sample := range inWindow(r, m, now, window) {
+				values = append(values, sample.Value)
+			}
+		default:
+			values = []float64{current(m)}
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		key, labels := groupOf(q.GroupBy, m)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+		}
+		g.values = append(g.values, values...)
+		g.series++
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	results := make([]Result, 0, len(keys))
+	for _, k := range keys {
+		g := groups[k]
+		results = append(results, Result{
+			Group:  g.labels,
+			Value:  aggregate(q.Func, g.values),
+			Series: g.series,
+		})
+	}
+
+	return results, nil
+}
+
+func current(m storage.Metrics) float64 {
+	if m.Delta != nil {
+		return float64(*m.Delta)
+	}
+	if m.Value != nil {
+		return *m.Value
+	}
+	return 0
+}
+
+// MatchLabels сообщает, что у ряда есть все метки labels. Пустое значение
+// совпадает с рядом без метки.
+func MatchLabels(labels map[string]string, m storage.Metrics) bool {
+	for k, v := range labels {
+		if m.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func inWindow(r storage.Reader, m storage.Metrics, now time.Time, window time.Duration) []storage.Sample {
+	since := now.Add(-window)
+	h := r.SeriesHistory(m.MType, m.ID, m.Labels[storage.LabelAgent])
+
+	i := sort.Search(len(h), func(i int) bool {
+		return !h[i].Time.Before(since)
+	})
+	return h[i:]
+}
+
+// seriesRate - скорость изменения метрики в секунду. Уменьшение счётчика
+// считается его сбросом, как после ResetCounter или перезапуска.
+func seriesRate(mType string, samples []storage.Sample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	seconds := last.Time.Sub(first.Time).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+
+	if mType != storage.CounterType {
+		return (last.Value - first.Value) / seconds, true
+	}
+
+	var increase float64
+	for i := 1; i < len(samples); i++ {
+		if d := samples[i].Value - samples[i-1].Value; d >= 0 {
+			increase += d
+		} else {
+			increase += samples[i].Value
+		}
+	}
+	return increase / seconds, true
+}
+
+func groupOf(groupBy []string, m storage.Metrics) (string, map[string]string) {
+	labels := make(map[string]string, len(groupBy))
+	parts := make([]string, 0, len(groupBy))
+
+	for _, g := range groupBy {
+		var v string
+		switch g {
+		case GroupByName:
+			v = m.ID
+		case GroupByType:
+			v = m.MType
+		case GroupByAgent:
+			v = m.Labels[storage.LabelAgent]
+		}
+		labels[g] = v
+		parts = append(parts, g+"="+v)
+	}
+
+	return strings.Join(parts, ","), labels
+}
+
+func aggregate(fn string, values []float64) float64 {
+	switch fn {
+	case FuncCount:
+		return float64(len(values))
+	case FuncMin:
+		res := math.Inf(1)
+		for _, v := range values {
+			res = math.Min(res, v)
+		}
+		return res
+	case FuncMax:
+		res := math.Inf(-1)
+		for _, v := range values {
+			res = math.Max(res, v)
+		}
+		return res
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	if fn == FuncAvg {
+		return sum / float64(len(values))
+	}
+	return sum
+}
@@ -0,0 +1,139 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/storage/storagetest"
+)
+
+var now = storagetest.Now
+
+func newFakeReader() *storagetest.Reader {
+	gauge, counter, on, at := storagetest.Gauge, storagetest.Counter, storagetest.On, storagetest.At
+
+	return &storagetest.Reader{
+		Metrics: []storage.Metrics{
+			gauge("HeapAlloc", 100),
+			gauge("HeapSys", 300),
+			gauge("Alloc", 50),
+			counter("PollCount", 40),
+			on("host1", gauge("Goroutines", 10)),
+			on("host2", gauge("Goroutines", 30)),
+		},
+		Samples: map[string][]storage.Sample{
+			"gauge/HeapAlloc":        {at(-120, 500), at(-20, 80), at(0, 100)},
+			"gauge/HeapSys":          {at(-30, 200), at(0, 300)},
+			"counter/PollCount":      {at(-40, 10), at(-30, 20), at(-20, 5), at(0, 40)},
+			"gauge/Goroutines/host1": {at(-10, 50), at(0, 10)},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	type testType struct {
+		name     string
+		query    Query
+		expected []Result
+		wantErr  bool
+	}
+
+	tests := []testType{
+		{
+			name:  "Sum current gauges",
+			query: Query{Func: FuncSum, MType: storage.GaugeType, Match: "Heap*"},
+			expected: []Result{
+				{Group: map[string]string{}, Value: 400, Series: 2},
+			},
+		},
+		{
+			name:  "Avg grouped by type",
+			query: Query{Func: FuncAvg, GroupBy: []string{GroupByType}},
+			expected: []Result{
+				{Group: map[string]string{"type": "counter"}, Value: 40, Series: 1},
+				{Group: map[string]string{"type": "gauge"}, Value: 98, Series: 5},
+			},
+		},
+		{
+			name:  "Max over history window",
+			query: Query{Func: FuncMax, Match: "Heap*", Range: "1m"},
+			expected: []Result{
+				{Group: map[string]string{}, Value: 300, Series: 2},
+			},
+		},
+		{
+			name:  "Min over history window by name",
+			query: Query{Func: FuncMin, MType: storage.GaugeType, Match: "Heap*", GroupBy: []string{GroupByName}, Range: "1m"},
+			expected: []Result{
+				{Group: map[string]string{"name": "HeapAlloc"}, Value: 80, Series: 1},
+				{Group: map[string]string{"name": "HeapSys"}, Value: 200, Series: 1},
+			},
+		},
+		{
+			name:  "Counter rate with reset",
+			query: Query{Func: FuncRate, Match: "PollCount", Range: "1m"},
+			expected: []Result{
+				// 10 -> 20 (+10), сброс до 5 (+5), 5 -> 40 (+35) за 40 секунд
+				{Group: map[string]string{}, Value: 50.0 / 40, Series: 1},
+			},
+		},
+		{
+			name:  "Sum across agents",
+			query: Query{Func: FuncSum, Match: "Goroutines"},
+			expected: []Result{
+				{Group: map[string]string{}, Value: 40, Series: 2},
+			},
+		},
+		{
+			name:  "Sum grouped by agent",
+			query: Query{Func: FuncSum, MType: storage.GaugeType, GroupBy: []string{GroupByAgent}},
+			expected: []Result{
+				{Group: map[string]string{"agent": ""}, Value: 450, Series: 3},
+				{Group: map[string]string{"agent": "host1"}, Value: 10, Series: 1},
+				{Group: map[string]string{"agent": "host2"}, Value: 30, Series: 1},
+			},
+		},
+		{
+			name:  "Filter by agent",
+			query: Query{Func: FuncMax, Labels: map[string]string{"agent": "host1"}, Range: "1m"},
+			expected: []Result{
+				{Group: map[string]string{}, Value: 50, Series: 1},
+			},
+		},
+		{
+			name:    "Unknown label",
+			query:   Query{Func: FuncSum, Labels: map[string]string{"host": "a"}},
+			wantErr: true,
+		},
+		{
+			name:    "Rate without range",
+			query:   Query{Func: FuncRate},
+			wantErr: true,
+		},
+		{
+			name:    "Group by unknown label",
+			query:   Query{Func: FuncSum, GroupBy: []string{"host"}},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown func",
+			query:   Query{Func: "median"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := Evaluate(newFakeReader(), test.query, now)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
@@ -1,24 +1,202 @@
 package routers
 
 import (
+	"crypto/rsa"
+	"net"
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+	"github.com/pavelborisofff/go-metrics/internal/crypto"
 	"github.com/pavelborisofff/go-metrics/internal/gzip"
 	"github.com/pavelborisofff/go-metrics/internal/handlers"
+	"github.com/pavelborisofff/go-metrics/internal/limit"
 	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/selfmetrics"
+	"github.com/pavelborisofff/go-metrics/internal/subnet"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
 )
 
-func InitRouter() *chi.Mux {
+type options struct {
+	privateKey    *rsa.PrivateKey
+	trustedSubnet *net.IPNet
+	tokens        *auth.Store
+	limiter       *limit.Limiter
+	maxBody       int64
+	maxDecoded    int64
+	maxRatio      float64
+	reload        func() error
+	health        *handlers.Health
+	storeFile     string
+	pprof         bool
+}
+
+type Option func(*options)
+
+// WithPrivateKey включает расшифровку тел запросов, зашифрованных агентом.
+func WithPrivateKey(key *rsa.PrivateKey) Option {
+	return func(o *options) {
+		o.privateKey = key
+	}
+}
+
+// WithTrustedSubnet разрешает изменять метрики только агентам из подсети.
+func WithTrustedSubnet(trusted *net.IPNet) Option {
+	return func(o *options) {
+		o.trustedSubnet = trusted
+	}
+}
+
+// WithAuth требует bearer-токен: read для чтения, write для изменения
+// метрик, admin для управления сервером.
+func WithAuth(tokens *auth.Store) Option {
+	return func(o *options) {
+		o.tokens = tokens
+	}
+}
+
+// WithRateLimit ограничивает частоту запросов каждого клиента.
+func WithRateLimit(l *limit.Limiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+// WithBodyLimits задаёт максимальный размер тела запроса до и после
+// распаковки, 0 - без ограничения.
+func WithBodyLimits(maxBody, maxDecoded int64) Option {
+	return func(o *options) {
+		o.maxBody = maxBody
+		o.maxDecoded = maxDecoded
+	}
+}
+
+// WithMaxRatio ограничивает степень сжатия тела запроса, 0 - без ограничения.
+func WithMaxRatio(ratio float64) Option {
+	return func(o *options) {
+		o.maxRatio = ratio
+	}
+}
+
+// WithHealth включает пробы /healthz, /readyz и /ping. Они доступны
+// без аутентификации и лимитов.
+func WithHealth(h *handlers.Health) Option {
+	return func(o *options) {
+		o.health = h
+	}
+}
+
+// WithReload включает POST /admin/reload, перечитывающий конфигурацию.
+// Как и остальные админские маршруты, он подключается только с WithAuth.
+func WithReload(reload func() error) Option {
+	return func(o *options) {
+		o.reload = reload
+	}
+}
+
+// WithStoreFile включает POST /admin/snapshot и /admin/restore для файла
+// хранилища file.
+func WithStoreFile(file string) Option {
+	return func(o *options) {
+		o.storeFile = file
+	}
+}
+
+// WithPprof включает net/http/pprof под /debug/pprof, доступный как
+// остальные админские маршруты, то есть только с WithAuth.
+func WithPprof() Option {
+	return func(o *options) {
+		o.pprof = true
+	}
+}
+
+func InitRouter(opts ...Option) *chi.Mux {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	r := chi.NewRouter()
+	r.Use(tracing.Handle)
+	r.Use(logger.RequestIDHandle)
 	r.Use(logger.LogHandle)
-	r.Use(gzip.GzipHandle)
-
-	r.Get("/", handlers.MainHandler)
-	r.Post("/update/{metric-type}/{metric-name}/{metric-value}", handlers.UpdateHandler)
-	r.Get("/value/{metric-type}/{metric-name}", handlers.ValueHandler)
-	r.Post("/update/", handlers.UpdateJSONHandler)
-	r.Post("/value/", handlers.ValueJSONHandler)
-	r.Get("/metrics", handlers.MetricsHandler)
+	r.Use(selfmetrics.Handle(selfmetrics.Default))
+	r.Use(limit.Body(o.maxBody))
+	r.Use(crypto.DecryptHandle(o.privateKey))
+	r.Use(gzip.GzipHandle(o.maxDecoded, o.maxRatio))
+
+	if o.health != nil {
+		r.Get("/healthz", o.health.LiveHandler)
+		r.Get("/readyz", o.health.ReadyHandler)
+		r.Get("/ping", o.health.PingHandler)
+	}
+
+	// Страницы браузера: токен можно передать в access_token
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireQuery(o.tokens, auth.ScopeRead))
+		r.Use(limit.Handle(o.limiter))
+
+		r.Get("/", handlers.MainHandler)
+		r.Get("/stream", handlers.StreamHandler)
+		r.Get("/ws", handlers.DashboardWSHandler)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Require(o.tokens, auth.ScopeRead))
+		r.Use(limit.Handle(o.limiter))
+
+		r.Get("/value/{metric-type}/{metric-name}", handlers.ValueHandler)
+		r.Post("/value/", handlers.ValueJSONHandler)
+		r.Get("/metrics", handlers.MetricsHandler)
+		r.Method(http.MethodGet, "/internal/metrics", selfmetrics.Handler(selfmetrics.Default))
+		r.Get("/api/v1/metrics", handlers.QueryHandler)
+		r.Post("/api/v1/query", handlers.AggregateHandler)
+		r.Get("/api/v1/export/values", handlers.ValuesExportHandler)
+		r.Get("/api/v1/export/history", handlers.HistoryExportHandler)
+	})
+
+	// Всё, что меняет хранилище
+	r.Group(func(r chi.Router) {
+		r.Use(subnet.Handle(o.trustedSubnet))
+		r.Use(auth.Require(o.tokens, auth.ScopeWrite))
+		r.Use(limit.Handle(o.limiter))
+
+		r.Post("/update/{metric-type}/{metric-name}/{metric-value}", handlers.UpdateHandler)
+		r.Post("/update/", handlers.UpdateJSONHandler)
+		r.Delete("/value/{metric-type}/{metric-name}", handlers.DeleteHandler)
+		r.Post("/reset/{metric-name}", handlers.ResetHandler)
+		r.Post("/delete/", handlers.DeleteJSONHandler)
+	})
+
+	// Без токенов админские маршруты не подключаются: auth.Require
+	// пропустил бы любой запрос
+	if o.tokens == nil {
+		return r
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(subnet.Handle(o.trustedSubnet))
+		r.Use(auth.Require(o.tokens, auth.ScopeAdmin))
+		r.Use(limit.Handle(o.limiter))
+
+		r.Method(http.MethodGet, "/admin/log-level", logger.LevelHandler())
+		r.Method(http.MethodPut, "/admin/log-level", logger.LevelHandler())
+		r.Get("/admin/stats", handlers.StatsHandler)
+		r.Get("/admin/export", handlers.ExportHandler)
+		r.Post("/admin/import", handlers.ImportHandler)
+		if o.reload != nil {
+			r.Post("/admin/reload", handlers.ReloadHandler(o.reload))
+		}
+		if o.storeFile != "" {
+			r.Post("/admin/snapshot", handlers.SnapshotHandler(o.storeFile))
+			r.Post("/admin/restore", handlers.RestoreHandler(o.storeFile))
+		}
+		if o.pprof {
+			r.Mount("/debug", middleware.Profiler())
+		}
+	})
 
 	return r
 }
@@ -1,10 +1,23 @@
 package routers
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+	"github.com/pavelborisofff/go-metrics/internal/config"
+	"github.com/pavelborisofff/go-metrics/internal/handlers"
+	"github.com/pavelborisofff/go-metrics/internal/limit"
+	"github.com/pavelborisofff/go-metrics/internal/subnet"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestInitRouter(t *testing.T) {
@@ -15,4 +28,337 @@ func TestInitRouter(t *testing.T) {
 
 	r.ServeHTTP(res, req)
 	assert.Equal(t, http.StatusOK, res.Code)
+	assert.NotEmpty(t, res.Header().Get("X-Request-ID"))
+}
+
+func TestTrustedSubnet(t *testing.T) {
+	trusted, err := subnet.Parse("10.0.0.0/8")
+	require.NoError(t, err)
+	r := InitRouter(WithTrustedSubnet(trusted))
+
+	type testType struct {
+		name         string
+		method       string
+		url          string
+		realIP       string
+		expectedCode int
+	}
+
+	tests := []testType{
+		{name: "Write from trusted subnet", method: http.MethodPost, url: "/update/gauge/subnetGauge/1", realIP: "10.0.0.5", expectedCode: http.StatusOK},
+		{name: "Write from outside", method: http.MethodPost, url: "/update/gauge/subnetGauge/1", realIP: "192.168.0.5", expectedCode: http.StatusForbidden},
+		{name: "Delete without IP", method: http.MethodDelete, url: "/value/gauge/subnetGauge", expectedCode: http.StatusForbidden},
+		{name: "Read from outside", method: http.MethodGet, url: "/value/gauge/subnetGauge", realIP: "192.168.0.5", expectedCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, nil)
+			if test.realIP != "" {
+				req.Header.Set(subnet.Header, test.realIP)
+			}
+			res := httptest.NewRecorder()
+
+			r.ServeHTTP(res, req)
+			assert.Equal(t, test.expectedCode, res.Code)
+		})
+	}
+}
+
+func TestStream(t *testing.T) {
+	ts := httptest.NewServer(InitRouter())
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Поток должен работать и через gzip, клиент сам распакует ответ
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/stream?name=streamGauge&label=agent=host2", nil)
+	require.NoError(t, err)
+
+	res, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	for _, u := range []struct{ name, agent string }{{"otherGauge", "host2"}, {"streamGauge", "host1"}, {"streamGauge", "host2"}} {
+		upd, err := http.NewRequest(http.MethodPost, ts.URL+"/update/gauge/"+u.name+"/5", nil)
+		require.NoError(t, err)
+		upd.Header.Set(limit.AgentHeader, u.agent)
+		updRes, err := ts.Client().Do(upd)
+		require.NoError(t, err)
+		updRes.Body.Close()
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() && len(lines) < 2 {
+		if scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+	}
+
+	require.Len(t, lines, 2)
+	assert.Equal(t, "event: metric", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "data: "))
+	assert.JSONEq(t, `{"id":"streamGauge","type":"gauge","value":5,"labels":{"agent":"host2"}}`, strings.TrimPrefix(lines[1], "data: "))
+}
+
+func TestAgentSeries(t *testing.T) {
+	r := InitRouter()
+	t.Cleanup(func() {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/delete/", strings.NewReader(`{"pattern":"agentHeap"}`)))
+	})
+
+	for agent, v := range map[string]string{"host1": "100", "host2": "200"} {
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/agentHeap/"+v, nil)
+		req.Header.Set(limit.AgentHeader, agent)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"Sum across agents", `{"func":"sum","match":"agentHeap"}`, `{"result":[{"group":{},"value":300,"series":2}]}`},
+		{"Group by agent", `{"func":"sum","match":"agentHeap","group_by":["agent"]}`,
+			`{"result":[{"group":{"agent":"host1"},"value":100,"series":1},{"group":{"agent":"host2"},"value":200,"series":1}]}`},
+		{"Filter by agent", `{"func":"max","match":"agentHeap","labels":{"agent":"host1"}}`, `{"result":[{"group":{},"value":100,"series":1}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/api/v1/query", strings.NewReader(tt.body)))
+			require.Equal(t, http.StatusOK, res.Code)
+			assert.JSONEq(t, tt.want, res.Body.String())
+		})
+	}
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/api/v1/metrics?prefix=agentHeap&label=agent=host2", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"metrics":[{"id":"agentHeap","type":"gauge","value":200,"labels":{"agent":"host2"}}]}`, res.Body.String())
+}
+
+func TestDashboardWS(t *testing.T) {
+	ts := httptest.NewServer(InitRouter())
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("Accept-Encoding", "gzip")
+
+	conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	var msg struct {
+		Type   string `json:"type"`
+		Metric struct {
+			ID    string  `json:"id"`
+			Value float64 `json:"value"`
+		} `json:"metric"`
+	}
+
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "snapshot", msg.Type)
+
+	upd, err := ts.Client().Post(ts.URL+"/update/gauge/wsGauge/7", "text/plain", nil)
+	require.NoError(t, err)
+	upd.Body.Close()
+
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "update", msg.Type)
+	assert.Equal(t, "wsGauge", msg.Metric.ID)
+	assert.Equal(t, float64(7), msg.Metric.Value)
+}
+
+func TestAuth(t *testing.T) {
+	tokens, err := auth.NewStore([]auth.Token{
+		{Name: "viewer", Token: "viewer-token", Scopes: []auth.Scope{auth.ScopeRead}},
+		{Name: "agent", Token: "agent-token", Scopes: []auth.Scope{auth.ScopeWrite}, Prefixes: []string{"authAgent"}},
+		{Name: "ops", Token: "ops-token", Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	require.NoError(t, err)
+	r := InitRouter(WithAuth(tokens), WithPprof())
+
+	type testType struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		token        string
+		expectedCode int
+	}
+
+	tests := []testType{
+		{name: "Read without token", method: http.MethodGet, url: "/metrics", expectedCode: http.StatusUnauthorized},
+		{name: "Read with viewer", method: http.MethodGet, url: "/metrics", token: "viewer-token", expectedCode: http.StatusOK},
+		{name: "Agent cannot read", method: http.MethodGet, url: "/metrics", token: "agent-token", expectedCode: http.StatusForbidden},
+		{name: "Viewer cannot write", method: http.MethodPost, url: "/update/gauge/authAgentGauge/1", token: "viewer-token", expectedCode: http.StatusForbidden},
+		{name: "Agent writes own prefix", method: http.MethodPost, url: "/update/gauge/authAgentGauge/1", token: "agent-token", expectedCode: http.StatusOK},
+		{name: "Agent writes foreign prefix", method: http.MethodPost, url: "/update/gauge/authOtherGauge/1", token: "agent-token", expectedCode: http.StatusForbidden},
+		{name: "Agent JSON foreign prefix", method: http.MethodPost, url: "/update/", body: `{"id":"authOtherGauge","type":"gauge","value":1}`, token: "agent-token", expectedCode: http.StatusForbidden},
+		{name: "Viewer cannot bulk delete", method: http.MethodPost, url: "/delete/", body: `{"pattern":"authAgent*"}`, token: "viewer-token", expectedCode: http.StatusForbidden},
+		{name: "Agent bulk delete foreign pattern", method: http.MethodPost, url: "/delete/", body: `{"pattern":"auth*"}`, token: "agent-token", expectedCode: http.StatusForbidden},
+		{name: "Agent bulk delete own prefix", method: http.MethodPost, url: "/delete/", body: `{"pattern":"authAgent*"}`, token: "agent-token", expectedCode: http.StatusOK},
+		{name: "Admin bulk delete", method: http.MethodPost, url: "/delete/", body: `{"pattern":"authAgent*"}`, token: "ops-token", expectedCode: http.StatusOK},
+		{name: "Viewer cannot see stats", method: http.MethodGet, url: "/admin/stats", token: "viewer-token", expectedCode: http.StatusForbidden},
+		{name: "Admin stats", method: http.MethodGet, url: "/admin/stats", token: "ops-token", expectedCode: http.StatusOK},
+		{name: "Query token on dashboard", method: http.MethodGet, url: "/?access_token=viewer-token", expectedCode: http.StatusOK},
+		{name: "Query token on API", method: http.MethodGet, url: "/metrics?access_token=viewer-token", expectedCode: http.StatusUnauthorized},
+		{name: "Query token on admin", method: http.MethodGet, url: "/admin/stats?access_token=ops-token", expectedCode: http.StatusUnauthorized},
+		{name: "Viewer export", method: http.MethodGet, url: "/api/v1/export/values?format=ndjson", token: "viewer-token", expectedCode: http.StatusOK},
+		{name: "Export without token", method: http.MethodGet, url: "/api/v1/export/history", expectedCode: http.StatusUnauthorized},
+		{name: "Viewer cannot export", method: http.MethodGet, url: "/admin/export", token: "viewer-token", expectedCode: http.StatusForbidden},
+		{name: "Admin export", method: http.MethodGet, url: "/admin/export", token: "ops-token", expectedCode: http.StatusOK},
+		{name: "Writer cannot import", method: http.MethodPost, url: "/admin/import?dry_run=true", token: "agent-token", expectedCode: http.StatusForbidden},
+		{name: "Pprof without token", method: http.MethodGet, url: "/debug/pprof/", expectedCode: http.StatusUnauthorized},
+		{name: "Viewer cannot profile", method: http.MethodGet, url: "/debug/pprof/", token: "viewer-token", expectedCode: http.StatusForbidden},
+		{name: "Admin pprof", method: http.MethodGet, url: "/debug/pprof/", token: "ops-token", expectedCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			res := httptest.NewRecorder()
+
+			r.ServeHTTP(res, req)
+			assert.Equal(t, test.expectedCode, res.Code)
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	r := InitRouter(
+		WithRateLimit(limit.NewLimiter(1, 2, limit.KeyIP)),
+		WithBodyLimits(64, 128),
+	)
+
+	t.Run("Body too large", func(t *testing.T) {
+		body := `{"id":"limitGauge","type":"gauge","value":1,"pad":"` + strings.Repeat("x", 64) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+		req.RemoteAddr = "10.1.0.1:1234"
+		res := httptest.NewRecorder()
+
+		r.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+	})
+
+	t.Run("Rate limit", func(t *testing.T) {
+		codes := make([]int, 0, 3)
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/value/gauge/limitGauge", nil)
+			req.RemoteAddr = "10.1.0.2:1234"
+			res := httptest.NewRecorder()
+
+			r.ServeHTTP(res, req)
+			codes = append(codes, res.Code)
+			if res.Code == http.StatusTooManyRequests {
+				assert.NotEmpty(t, res.Header().Get("Retry-After"))
+			}
+		}
+		assert.Equal(t, http.StatusTooManyRequests, codes[2])
+		assert.NotEqual(t, http.StatusTooManyRequests, codes[0])
+	})
+}
+
+func TestReload(t *testing.T) {
+	tokens, err := auth.NewStore([]auth.Token{{Name: "ops", Token: "ops-token", Scopes: []auth.Scope{auth.ScopeAdmin}}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"Applied", nil, http.StatusOK},
+		{"Restart required", fmt.Errorf("%w: address", config.ErrRestartRequired), http.StatusConflict},
+		{"Invalid config", fmt.Errorf("log_level: bad"), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := InitRouter(WithAuth(tokens), WithReload(func() error { return tt.err }))
+			req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+			req.Header.Set("Authorization", "Bearer ops-token")
+			res := httptest.NewRecorder()
+
+			r.ServeHTTP(res, req)
+			assert.Equal(t, tt.want, res.Code)
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.Header.Set("Authorization", "Bearer ops-token")
+	res := httptest.NewRecorder()
+	InitRouter(WithAuth(tokens)).ServeHTTP(res, req)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestAdminWithoutAuth(t *testing.T) {
+	r := InitRouter(
+		WithReload(func() error { return nil }),
+		WithStoreFile(filepath.Join(t.TempDir(), "metrics.json")),
+		WithPprof(),
+	)
+
+	routes := []struct {
+		method string
+		url    string
+	}{
+		{http.MethodPost, "/admin/reload"},
+		{http.MethodPost, "/admin/restore"},
+		{http.MethodPost, "/admin/import"},
+		{http.MethodGet, "/admin/export"},
+		{http.MethodPut, "/admin/log-level"},
+		{http.MethodGet, "/debug/pprof/"},
+	}
+	for _, rt := range routes {
+		t.Run(rt.method+" "+rt.url, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, httptest.NewRequest(rt.method, rt.url, nil))
+			assert.Equal(t, http.StatusNotFound, res.Code)
+		})
+	}
+}
+
+func TestSelfMetrics(t *testing.T) {
+	r := InitRouter()
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/update/gauge/_self.goroutines/1", nil))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/update/gauge/selfMetricsGauge/1", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), `route="/update/{metric-type}/{metric-name}/{metric-value}",status="403"`)
+	assert.Contains(t, res.Body.String(), `metrics_server_ingested_metrics_total{transport="http"}`)
+	assert.Contains(t, res.Body.String(), "metrics_server_storage_series ")
+}
+
+func TestHealthProbes(t *testing.T) {
+	h := handlers.NewHealth(nil)
+	h.SetRestored()
+	tokens, err := auth.NewStore([]auth.Token{{Name: "reader", Token: "read-token", Scopes: []auth.Scope{auth.ScopeRead}}})
+	require.NoError(t, err)
+	r := InitRouter(WithHealth(h), WithAuth(tokens))
+
+	// Пробы доступны без токена
+	for _, path := range []string{"/healthz", "/readyz", "/ping"} {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, res.Code, path)
+	}
 }
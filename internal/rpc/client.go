@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/pavelborisofff/go-metrics/internal/limit"
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+	pb "github.com/pavelborisofff/go-metrics/internal/proto"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/subnet"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
+)
+
+const sendTimeout = 5 * time.Second
+
+// Client отправляет метрики агента по gRPC одним пакетом вместо
+// отдельного HTTP-запроса на каждую метрику.
+type Client struct {
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+}
+
+func NewClient(addr string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		tracing.WithClientTrace(),
+	}, opts...)
+
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn, client: pb.NewMetricsClient(conn)}, nil
+}
+
+// WithRealIP передаёт IP агента в метаданных каждого вызова.
+func WithRealIP(ip string) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, subnet.MetadataKey, ip)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
+
+// WithAgentID передаёт идентификатор агента для лимитов сервера.
+func WithAgentID(id string) grpc.DialOption {
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, limit.AgentMetadataKey, id)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
+
+func (c *Client) SendMetrics(ctx context.Context, metrics []storage.Metrics) error {
+	req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, m := range metrics {
+		req.Metrics = append(req.Metrics, toProto(m))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	id := logger.NewRequestID()
+	ctx = metadata.AppendToOutgoingContext(ctx, logger.RequestIDMetadataKey, id)
+	log := log.With(zap.String("request_id", id))
+
+	if _, err := c.client.UpdateMetrics(ctx, req); err != nil {
+		log.Error("Error sending metrics via gRPC", zap.Error(err))
+		return err
+	}
+
+	log.Info("Metrics sent via gRPC", zap.Int("count", len(metrics)))
+	return nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,24 @@
+package rpc
+
+import (
+	pb "github.com/pavelborisofff/go-metrics/internal/proto"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+func fromProto(m *pb.Metric) storage.Metrics {
+	return storage.Metrics{
+		ID:    m.GetId(),
+		MType: m.GetType(),
+		Delta: m.Delta,
+		Value: m.Value,
+	}
+}
+
+func toProto(m storage.Metrics) *pb.Metric {
+	return &pb.Metric{
+		Id:    m.ID,
+		Type:  m.MType,
+		Delta: m.Delta,
+		Value: m.Value,
+	}
+}
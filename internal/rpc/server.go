@@ -0,0 +1,224 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/pavelborisofff/go-metrics/internal/auth"
+	"github.com/pavelborisofff/go-metrics/internal/limit"
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+	pb "github.com/pavelborisofff/go-metrics/internal/proto"
+	"github.com/pavelborisofff/go-metrics/internal/selfmetrics"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
+)
+
+var log = logger.GetLogger()
+
+// MetricsServer - gRPC-транспорт поверх того же хранилища и Hub, что и HTTP API.
+type MetricsServer struct {
+	pb.UnimplementedMetricsServer
+	storage *storage.MemStorage
+	hub     *stream.Hub
+}
+
+func NewMetricsServer(s *storage.MemStorage, h *stream.Hub) *MetricsServer {
+	return &MetricsServer{storage: s, hub: h}
+}
+
+// NewServer создаёт grpc.Server с зарегистрированным сервисом метрик.
+func NewServer(s *storage.MemStorage, h *stream.Hub, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor, logUnary),
+		grpc.ChainStreamInterceptor(logStream),
+	}, opts...)
+
+	srv := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(srv, NewMetricsServer(s, h))
+	return srv
+}
+
+func storageError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrBadType), errors.Is(err, storage.ErrNoValue):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (m *MetricsServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	metrics := make([]storage.Metrics, 0, len(req.GetMetrics()))
+	for _, metric := range req.GetMetrics() {
+		if strings.HasPrefix(metric.GetId(), selfmetrics.Prefix) {
+			return nil, status.Errorf(codes.PermissionDenied, "reserved metric name: %s", metric.GetId())
+		}
+		if !auth.Allowed(ctx, metric.GetId()) {
+			return nil, status.Errorf(codes.PermissionDenied, "forbidden metric: %s", metric.GetId())
+		}
+		metrics = append(metrics, fromProto(metric))
+	}
+
+	_, span := tracing.Start(ctx, "storage.ApplyMetrics", attribute.Int("metrics", len(metrics)))
+	agent := agentID(ctx)
+	applied, err := m.storage.ApplyMetricsFrom(agent, metrics)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	selfmetrics.Default.Ingested("grpc", len(applied))
+
+	res := &pb.UpdateMetricsResponse{Metrics: make([]*pb.Metric, 0, len(applied))}
+	updates := make([]storage.Metrics, 0, len(applied))
+	for _, metric := range applied {
+		res.Metrics = append(res.Metrics, toProto(metric))
+		if series, err := m.storage.GetSeries(metric.MType, metric.ID, agent); err == nil {
+			updates = append(updates, series)
+		}
+	}
+	m.hub.PublishAll(updates)
+
+	if t := auth.FromContext(ctx); t != nil {
+		logger.FromContext(ctx).Info("audit",
+			zap.String("token", t.Name),
+			zap.String("action", "update"),
+			zap.Int("count", len(applied)),
+		)
+	}
+
+	return res, nil
+}
+
+func (m *MetricsServer) GetMetric(_ context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	metric, err := m.storage.GetMetric(req.GetType(), req.GetId())
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	return &pb.GetMetricResponse{Metric: toProto(metric)}, nil
+}
+
+func (m *MetricsServer) StreamUpdates(req *pb.StreamUpdatesRequest, srv pb.Metrics_StreamUpdatesServer) error {
+	switch req.GetType() {
+	case "", storage.CounterType, storage.GaugeType:
+	default:
+		return status.Error(codes.InvalidArgument, storage.ErrBadType.Error())
+	}
+
+	if _, err := path.Match(req.GetName(), ""); err != nil {
+		return status.Error(codes.InvalidArgument, "bad name")
+	}
+
+	f := stream.Filter{Name: req.GetName(), MType: req.GetType()}
+	sub := m.hub.Subscribe(f)
+	defer m.hub.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-srv.Context().Done():
+			return nil
+		case <-m.hub.Done():
+			// Иначе GracefulStop ждал бы поток до таймаута
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case <-sub.Resync:
+			// Хранилище заменено целиком, отправляем текущие значения
+			for _, metric := range m.storage.All() {
+				if !f.Match(metric) {
+					continue
+				}
+				if err := srv.Send(toProto(metric)); err != nil {
+					return err
+				}
+			}
+		case metric, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber is too slow")
+			}
+			// В Hub обновления рядов агентов, у Metric меток нет, поэтому
+			// отправляется общее значение метрики
+			if !stream.IsRemoved(metric) {
+				var err error
+				if metric, err = m.storage.GetMetric(metric.MType, metric.ID); err != nil {
+					continue
+				}
+			}
+			if err := srv.Send(toProto(metric)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// agentID - идентификатор агента из метаданных, по нему хранилище ведёт
+// ряды метрики (storage.LabelAgent).
+func agentID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(limit.AgentMetadataKey); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// requestID берёт идентификатор запроса из метаданных или создаёт новый.
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(logger.RequestIDMetadataKey); len(v) > 0 && logger.ValidRequestID(v[0]) {
+		return v[0]
+	}
+	return logger.NewRequestID()
+}
+
+type requestStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (r *requestStream) Context() context.Context {
+	return r.ctx
+}
+
+func logUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	id := requestID(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(logger.RequestIDMetadataKey, id))
+	ctx = logger.WithRequestID(ctx, id)
+
+	res, err := handler(ctx, req)
+
+	logger.FromContext(ctx).Info("grpc request",
+		zap.String("method", info.FullMethod),
+		zap.String("code", status.Code(err).String()),
+		zap.Duration("duration", time.Since(start)),
+	)
+	return res, err
+}
+
+func logStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	id := requestID(ss.Context())
+	ss.SetHeader(metadata.Pairs(logger.RequestIDMetadataKey, id))
+	ctx := logger.WithRequestID(ss.Context(), id)
+
+	err := handler(srv, &requestStream{ServerStream: ss, ctx: ctx})
+
+	logger.FromContext(ctx).Info("grpc stream",
+		zap.String("method", info.FullMethod),
+		zap.String("code", status.Code(err).String()),
+		zap.Duration("duration", time.Since(start)),
+	)
+	return err
+}
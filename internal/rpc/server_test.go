@@ -0,0 +1,141 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+	pb "github.com/pavelborisofff/go-metrics/internal/proto"
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/stream"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
+)
+
+func newTestClient(t *testing.T, hub *stream.Hub) (*Client, pb.MetricsClient) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := NewServer(storage.NewMemStorage(), hub)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	c, err := NewClient("bufnet", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	return c, c.client
+}
+
+func TestMetricsServer_UpdateMetrics(t *testing.T) {
+	c, client := newTestClient(t, stream.NewHub(10))
+	ctx := context.Background()
+
+	delta := int64(3)
+	value := 1.5
+	batch := []storage.Metrics{
+		{ID: "rpcCounter", MType: storage.CounterType, Delta: &delta},
+		{ID: "rpcCounter", MType: storage.CounterType, Delta: &delta},
+		{ID: "rpcGauge", MType: storage.GaugeType, Value: &value},
+	}
+	require.NoError(t, c.SendMetrics(ctx, batch))
+
+	res, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "rpcCounter", Type: storage.CounterType})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), res.GetMetric().GetDelta())
+
+	res, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "rpcGauge", Type: storage.GaugeType})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, res.GetMetric().GetValue())
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "rpcMissing", Type: storage.GaugeType})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Некорректная метрика отклоняет весь пакет
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "rpcCounter", Type: storage.CounterType, Delta: &delta},
+		{Id: "rpcGauge", Type: storage.GaugeType},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "rpcCounter", Type: storage.CounterType})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), res.GetMetric().GetDelta())
+}
+
+func TestMetricsServer_StreamUpdates(t *testing.T) {
+	hub := stream.NewHub(10)
+	c, client := newTestClient(t, hub)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates, err := client.StreamUpdates(ctx, &pb.StreamUpdatesRequest{Name: "rpcStream*"})
+	require.NoError(t, err)
+
+	// Ждём, пока поток подпишется
+	require.Eventually(t, func() bool { return hub.Len() == 1 }, time.Second, 10*time.Millisecond)
+
+	value := 2.5
+	require.NoError(t, c.SendMetrics(ctx, []storage.Metrics{
+		{ID: "rpcOther", MType: storage.GaugeType, Value: &value},
+		{ID: "rpcStreamGauge", MType: storage.GaugeType, Value: &value},
+	}))
+
+	m, err := updates.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "rpcStreamGauge", m.GetId())
+	assert.Equal(t, 2.5, m.GetValue())
+}
+
+func TestRequestID(t *testing.T) {
+	_, client := newTestClient(t, stream.NewHub(10))
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), logger.RequestIDMetadataKey, "agent-42")
+	_, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "rpcMissing", Type: storage.GaugeType}, grpc.Header(&header))
+	require.Error(t, err)
+	assert.Equal(t, []string{"agent-42"}, header.Get(logger.RequestIDMetadataKey))
+
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "rpcMissing", Type: storage.GaugeType}, grpc.Header(&header))
+	require.Error(t, err)
+	assert.Len(t, header.Get(logger.RequestIDMetadataKey)[0], 32)
+}
+
+func TestTracing(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider("test", sdktrace.NewSimpleSpanProcessor(exp))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	c, _ := newTestClient(t, stream.NewHub(10))
+	value := 1.0
+	require.NoError(t, c.SendMetrics(context.Background(), []storage.Metrics{
+		{ID: "rpcTraced", MType: storage.GaugeType, Value: &value},
+	}))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range exp.GetSpans().Snapshots() {
+		spans[s.Name()] = s
+	}
+	client := spans[pb.Metrics_UpdateMetrics_FullMethodName]
+	require.Contains(t, spans, "storage.ApplyMetrics")
+	require.NotNil(t, client)
+
+	// Клиентский, серверный спан и запись в хранилище - одна трасса
+	apply := spans["storage.ApplyMetrics"]
+	assert.Equal(t, client.SpanContext().TraceID(), apply.SpanContext().TraceID())
+	assert.NotEqual(t, client.SpanContext().SpanID(), apply.Parent().SpanID())
+}
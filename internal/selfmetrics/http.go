@@ -0,0 +1,28 @@
+package selfmetrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/pavelborisofff/go-metrics/internal/logger"
+)
+
+// Handle учитывает запросы в r. Маршрут берётся из chi, чтобы число рядов
+// не зависело от имён метрик в URL.
+func Handle(r *Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			ww := logger.WrapWriter(w, req)
+			next.ServeHTTP(ww, req)
+
+			route := "unmatched"
+			if rc := chi.RouteContext(req.Context()); rc != nil && rc.RoutePattern() != "" {
+				route = rc.RoutePattern()
+			}
+			r.ObserveRequest(req.Method, route, logger.Status(ww, req), time.Since(start))
+		})
+	}
+}
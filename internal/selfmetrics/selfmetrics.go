@@ -0,0 +1,296 @@
+// Package selfmetrics собирает метрики самого сервера: запросы и их
+// длительность по маршрутам, скорость приёма метрик, сохранения на диск,
+// степень сжатия тел запросов и число горутин.
+package selfmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Prefix - зарезервированный префикс имён, под которым сервер пишет
+// свои метрики в хранилище. Клиентам писать под ним нельзя.
+const Prefix = "_self."
+
+// rateWindow - окно, за которое считается скорость приёма метрик
+const rateWindow = 60
+
+// Границы корзин гистограммы длительности запросов, в секундах
+var buckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type routeKey struct {
+	method, route string
+}
+
+type requestKey struct {
+	routeKey
+	status int
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type slot struct {
+	sec   int64
+	count uint64
+}
+
+type gaugeFunc struct {
+	name, help string
+	f          func() float64
+}
+
+// Registry хранит метрики сервера. Обычно используется Default.
+type Registry struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	latency  map[routeKey]*histogram
+	ingested map[string]uint64
+	window   [rateWindow]slot
+
+	snapshots        uint64
+	snapshotDuration float64
+	snapshotSize     int64
+
+	encodedBytes uint64
+	decodedBytes uint64
+
+	gauges []gaugeFunc
+	now    func() time.Time
+}
+
+// Default - общий реестр сервера.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		requests: make(map[requestKey]uint64),
+		latency:  make(map[routeKey]*histogram),
+		ingested: make(map[string]uint64),
+		now:      time.Now,
+	}
+	r.GaugeFunc("goroutines", "Number of goroutines.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	return r
+}
+
+// GaugeFunc добавляет метрику, значение которой вычисляется при выдаче.
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges = append(r.gauges, gaugeFunc{name: name, help: help, f: f})
+}
+
+// ObserveRequest учитывает обработанный HTTP-запрос.
+func (r *Registry) ObserveRequest(method, route string, status int, d time.Duration) {
+	k := routeKey{method: method, route: route}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests[requestKey{routeKey: k, status: status}]++
+	h, ok := r.latency[k]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(buckets))}
+		r.latency[k] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// Ingested учитывает n принятых метрик, transport - http или grpc.
+func (r *Registry) Ingested(transport string, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ingested[transport] += uint64(n)
+
+	sec := r.now().Unix()
+	s := &r.window[sec%rateWindow]
+	if s.sec != sec {
+		s.sec, s.count = sec, 0
+	}
+	s.count += uint64(n)
+}
+
+// ObserveSnapshot учитывает сохранение хранилища в файл размером size.
+func (r *Registry) ObserveSnapshot(d time.Duration, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshots++
+	r.snapshotDuration = d.Seconds()
+	r.snapshotSize = size
+}
+
+// ObserveDecode учитывает распакованное тело запроса.
+func (r *Registry) ObserveDecode(encoded, decoded int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.encodedBytes += uint64(encoded)
+	r.decodedBytes += uint64(decoded)
+}
+
+// rate - метрик в секунду за последние rateWindow секунд. Вызывать под mu.
+func (r *Registry) rate() float64 {
+	now := r.now().Unix()
+
+	var sum uint64
+	for _, s := range r.window {
+		if now-s.sec < rateWindow {
+			sum += s.count
+		}
+	}
+	return float64(sum) / rateWindow
+}
+
+// ratio - средняя степень сжатия тел запросов. Вызывать под mu.
+func (r *Registry) ratio() float64 {
+	if r.encodedBytes == 0 {
+		return 0
+	}
+	return float64(r.decodedBytes) / float64(r.encodedBytes)
+}
+
+// Values возвращает сводные значения для записи в хранилище под Prefix.
+func (r *Registry) Values() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var requests, ingested uint64
+	for _, n := range r.requests {
+		requests += n
+	}
+	for _, n := range r.ingested {
+		ingested += n
+	}
+
+	v := map[string]float64{
+		"http_requests_total":       float64(requests),
+		"ingested_metrics_total":    float64(ingested),
+		"ingestion_rate":            r.rate(),
+		"snapshots_total":           float64(r.snapshots),
+		"snapshot_duration_seconds": r.snapshotDuration,
+		"snapshot_size_bytes":       float64(r.snapshotSize),
+		"request_compression_ratio": r.ratio(),
+	}
+	for _, g := range r.gauges {
+		v[g.name] = g.f()
+	}
+	return v
+}
+
+func float(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WritePrometheus пишет метрики в текстовом формате Prometheus.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(bw, "# HELP metrics_server_%s %s\n# TYPE metrics_server_%s %s\n", name, help, name, typ)
+	}
+
+	metric("http_requests_total", "counter", "HTTP requests by route and status.")
+	keys := make([]requestKey, 0, len(r.requests))
+	for k := range r.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(bw, "metrics_server_http_requests_total{method=%q,route=%q,status=\"%d\"} %d\n",
+			k.method, k.route, k.status, r.requests[k])
+	}
+
+	metric("http_request_duration_seconds", "histogram", "HTTP request latency by route.")
+	routes := make([]routeKey, 0, len(r.latency))
+	for k := range r.latency {
+		routes = append(routes, k)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].route != routes[j].route {
+			return routes[i].route < routes[j].route
+		}
+		return routes[i].method < routes[j].method
+	})
+	for _, k := range routes {
+		h := r.latency[k]
+		labels := fmt.Sprintf("method=%q,route=%q", k.method, k.route)
+		for i, b := range buckets {
+			fmt.Fprintf(bw, "metrics_server_http_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, float(b), h.counts[i])
+		}
+		fmt.Fprintf(bw, "metrics_server_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(bw, "metrics_server_http_request_duration_seconds_sum{%s} %s\n", labels, float(h.sum))
+		fmt.Fprintf(bw, "metrics_server_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	metric("ingested_metrics_total", "counter", "Metric updates accepted by transport.")
+	transports := make([]string, 0, len(r.ingested))
+	for t := range r.ingested {
+		transports = append(transports, t)
+	}
+	sort.Strings(transports)
+	for _, t := range transports {
+		fmt.Fprintf(bw, "metrics_server_ingested_metrics_total{transport=%q} %d\n", t, r.ingested[t])
+	}
+
+	metric("ingestion_rate", "gauge", "Metric updates per second over the last minute.")
+	fmt.Fprintf(bw, "metrics_server_ingestion_rate %s\n", float(r.rate()))
+
+	metric("snapshots_total", "counter", "Storage snapshots saved to disk.")
+	fmt.Fprintf(bw, "metrics_server_snapshots_total %d\n", r.snapshots)
+	metric("snapshot_duration_seconds", "gauge", "Duration of the last snapshot.")
+	fmt.Fprintf(bw, "metrics_server_snapshot_duration_seconds %s\n", float(r.snapshotDuration))
+	metric("snapshot_size_bytes", "gauge", "Size of the last snapshot.")
+	fmt.Fprintf(bw, "metrics_server_snapshot_size_bytes %d\n", r.snapshotSize)
+
+	metric("request_compression_ratio", "gauge", "Decoded to encoded size of compressed request bodies.")
+	fmt.Fprintf(bw, "metrics_server_request_compression_ratio %s\n", float(r.ratio()))
+
+	for _, g := range r.gauges {
+		metric(g.name, "gauge", g.help)
+		fmt.Fprintf(bw, "metrics_server_%s %s\n", g.name, float(g.f()))
+	}
+
+	return bw.Flush()
+}
+
+// Handler отдаёт метрики реестра в формате Prometheus.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
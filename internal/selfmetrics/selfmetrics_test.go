@@ -0,0 +1,80 @@
+package selfmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle(t *testing.T) {
+	reg := NewRegistry()
+
+	r := chi.NewRouter()
+	r.Use(Handle(reg))
+	r.Get("/value/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "name") == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	for _, path := range []string{"/value/a", "/value/b", "/value/missing", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	res := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := res.Body.String()
+
+	for _, want := range []string{
+		`metrics_server_http_requests_total{method="GET",route="/value/{name}",status="200"} 2`,
+		`metrics_server_http_requests_total{method="GET",route="/value/{name}",status="404"} 1`,
+		`metrics_server_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`metrics_server_http_request_duration_seconds_count{method="GET",route="/value/{name}"} 3`,
+		`metrics_server_http_request_duration_seconds_bucket{method="GET",route="/value/{name}",le="+Inf"} 3`,
+		"# TYPE metrics_server_goroutines gauge",
+	} {
+		assert.Contains(t, body, want)
+	}
+}
+
+func TestIngestionRate(t *testing.T) {
+	reg := NewRegistry()
+	now := time.Unix(1000, 0)
+	reg.now = func() time.Time { return now }
+
+	reg.Ingested("http", 30)
+	now = now.Add(10 * time.Second)
+	reg.Ingested("grpc", 90)
+
+	v := reg.Values()
+	assert.Equal(t, 120.0, v["ingested_metrics_total"])
+	assert.Equal(t, 2.0, v["ingestion_rate"])
+
+	// Старые секунды выпадают из окна
+	now = now.Add(55 * time.Second)
+	assert.Equal(t, 1.5, reg.Values()["ingestion_rate"])
+}
+
+func TestValues(t *testing.T) {
+	reg := NewRegistry()
+	reg.GaugeFunc("storage_series", "Series.", func() float64 { return 7 })
+	reg.ObserveSnapshot(250*time.Millisecond, 4096)
+	reg.ObserveDecode(100, 1000)
+
+	v := reg.Values()
+	assert.Equal(t, 7.0, v["storage_series"])
+	assert.Equal(t, 0.25, v["snapshot_duration_seconds"])
+	assert.Equal(t, 4096.0, v["snapshot_size_bytes"])
+	assert.Equal(t, 10.0, v["request_compression_ratio"])
+	assert.Positive(t, v["goroutines"])
+
+	var b strings.Builder
+	require.NoError(t, reg.WritePrometheus(&b))
+	assert.Contains(t, b.String(), "metrics_server_storage_series 7\n")
+}
@@ -1,10 +1,18 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"crypto/rsa"
 	"encoding/json"
 	"fmt"
+	"github.com/pavelborisofff/go-metrics/internal/crypto"
 	"github.com/pavelborisofff/go-metrics/internal/gzip"
+	"github.com/pavelborisofff/go-metrics/internal/limit"
 	"github.com/pavelborisofff/go-metrics/internal/logger"
+	"github.com/pavelborisofff/go-metrics/internal/subnet"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"math/rand"
 	"net/http"
@@ -17,6 +25,32 @@ var (
 
 type AgentStorage struct {
 	MemStorage
+	// PublicKey - открытый ключ сервера, если задан, тело запросов шифруется
+	PublicKey *rsa.PublicKey
+	// RealIP - IP агента для проверки доверенной подсети на сервере
+	RealIP string
+	// Client - HTTP-клиент для отправки, по умолчанию http.DefaultClient
+	Client *http.Client
+	// Token - bearer-токен для сервера с аутентификацией
+	Token string
+	// AgentID - идентификатор агента для лимитов сервера
+	AgentID string
+	// Encoding - кодек сжатия тела запросов, по умолчанию gzip
+	Encoding string
+}
+
+func (s *AgentStorage) encoding() string {
+	if s.Encoding != "" {
+		return s.Encoding
+	}
+	return "gzip"
+}
+
+func (s *AgentStorage) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
 }
 
 func NewAgentStorage() *AgentStorage {
@@ -73,7 +107,7 @@ func (s *AgentStorage) SendMetrics(serverAddr string) error {
 	return nil
 }
 
-func (s *AgentStorage) SendJSONMetrics(serverAddr string) error {
+func (s *AgentStorage) SendJSONMetrics(ctx context.Context, serverAddr string) error {
 	var m Metrics
 
 	for name, value := range s.CounterStorage {
@@ -84,7 +118,7 @@ func (s *AgentStorage) SendJSONMetrics(serverAddr string) error {
 		}
 		*m.Delta = int64(value)
 
-		err := s.SendJSONMetric(m, serverAddr)
+		err := s.SendJSONMetric(ctx, m, serverAddr)
 		if err != nil {
 			return err
 		}
@@ -98,7 +132,7 @@ func (s *AgentStorage) SendJSONMetrics(serverAddr string) error {
 		}
 		*m.Value = float64(value)
 
-		err := s.SendJSONMetric(m, serverAddr)
+		err := s.SendJSONMetric(ctx, m, serverAddr)
 		if err != nil {
 			return err
 		}
@@ -107,30 +141,57 @@ func (s *AgentStorage) SendJSONMetrics(serverAddr string) error {
 	return nil
 }
 
-func (s *AgentStorage) SendJSONMetric(m Metrics, serverAddr string) error {
+func (s *AgentStorage) SendJSONMetric(ctx context.Context, m Metrics, serverAddr string) (err error) {
+	id := logger.NewRequestID()
+	log := log.With(zap.String("request_id", id))
+
+	ctx, span := tracing.Start(ctx, "agent.SendJSONMetric", attribute.String("metric", m.ID))
+	defer func() { tracing.End(span, err) }()
+
 	data, err := json.Marshal(m)
 	if err != nil {
 		log.Error("Error marshaling JSON data", zap.Error(err))
 		return err
 	}
 
-	compressedData, err := gzip.CompressData(data)
+	body, err := gzip.Compress(s.encoding(), data)
 	if err != nil {
 		log.Error("Error compressing JSON data", zap.Error(err))
 		return err
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/update/", serverAddr), compressedData)
+	if s.PublicKey != nil {
+		body, err = crypto.Encrypt(s.PublicKey, body)
+		if err != nil {
+			log.Error("Error encrypting JSON data", zap.Error(err))
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/update/", serverAddr), bytes.NewReader(body))
 	if err != nil {
 		log.Error("Error creating request JSON", zap.Error(err))
 		return err
 	}
 
 	req.Header.Set("Content-Type", "text/plain")
-	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(logger.RequestIDHeader, id)
+	tracing.Inject(ctx, req.Header)
+	req.Header.Set("Content-Encoding", s.encoding())
+	if s.PublicKey != nil {
+		req.Header.Set(crypto.Header, "1")
+	}
+	if s.RealIP != "" {
+		req.Header.Set(subnet.Header, s.RealIP)
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	if s.AgentID != "" {
+		req.Header.Set(limit.AgentHeader, s.AgentID)
+	}
 
-	c := &http.Client{}
-	res, err := c.Do(req)
+	res, err := s.client().Do(req)
 	if err != nil {
 		log.Error("Failed to send metric", zap.Error(err))
 		return err
@@ -139,7 +200,7 @@ func (s *AgentStorage) SendJSONMetric(m Metrics, serverAddr string) error {
 
 	if res.StatusCode != http.StatusOK {
 		log.Error("Error sending JSON", zap.String("status", res.Status))
-		return err
+		return fmt.Errorf("unexpected status: %s", res.Status)
 	}
 
 	log.Info("JSON sent successfully", zap.ByteString("data", data))
@@ -147,9 +208,30 @@ func (s *AgentStorage) SendJSONMetric(m Metrics, serverAddr string) error {
 }
 
 func (s *AgentStorage) SendMetric(metricType string, metricName string, metricValue interface{}, serverAddr string) error {
+	id := logger.NewRequestID()
+	log := log.With(zap.String("request_id", id))
+
 	url := fmt.Sprintf("%s/update/%s/%s/%v", serverAddr, metricType, metricName, metricValue)
 
-	res, err := http.Post(url, "text/plain", nil)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		log.Debug("Error creating request", zap.Error(err))
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(logger.RequestIDHeader, id)
+	if s.RealIP != "" {
+		req.Header.Set(subnet.Header, s.RealIP)
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	if s.AgentID != "" {
+		req.Header.Set(limit.AgentHeader, s.AgentID)
+	}
+
+	res, err := s.client().Do(req)
 	if err != nil {
 		log.Debug("Failed to send metric", zap.Error(err))
 		return err
@@ -0,0 +1,48 @@
+package storage
+
+import "time"
+
+// historySize - сколько последних значений хранится для каждой метрики.
+const historySize = 360
+
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Reader - доступ на чтение, которого достаточно для запросов и выгрузок.
+type Reader interface {
+	All() []Metrics
+	History(mType, name string) []Sample
+	Series() []Metrics
+	SeriesHistory(mType, name, agent string) []Sample
+}
+
+func historyKey(mType, name string) string {
+	return mType + "/" + name
+}
+
+func appendSample(h []Sample, value float64) []Sample {
+	if len(h) >= historySize {
+		h = h[len(h)-historySize+1:]
+	}
+	return append(h, Sample{Time: time.Now(), Value: value})
+}
+
+// record добавляет значение в историю метрики, вызывается под s.mu.
+func (s *MemStorage) record(mType, name string, value float64) {
+	key := historyKey(mType, name)
+	s.history[key] = appendSample(s.history[key], value)
+}
+
+// History возвращает копию истории значений метрики от старых к новым.
+// История хранится только в памяти и не попадает в файл.
+func (s *MemStorage) History(mType, name string) []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := s.history[historyKey(mType, name)]
+	res := make([]Sample, len(h))
+	copy(res, h)
+	return res
+}
@@ -0,0 +1,136 @@
+package storage
+
+// LabelAgent - метка ряда с идентификатором агента (X-Agent-ID или
+// метаданные gRPC). Метрика хранит общее значение, а по каждому агенту
+// ведётся отдельный ряд: у gauge - последнее значение агента, у
+// счётчика - сумма его приращений, так что сумма рядов счётчика равна
+// его значению.
+const LabelAgent = "agent"
+
+// source - ряд метрики от одного агента, пустой агент - запись без него.
+type source struct {
+	counter Counter
+	gauge   Gauge
+	history []Sample
+}
+
+func (src *source) value(mType string) float64 {
+	if mType == CounterType {
+		return float64(src.counter)
+	}
+	return float64(src.gauge)
+}
+
+// track обновляет ряд агента после записи, вызывается под s.mu. Для
+// счётчика value - приращение.
+func (s *MemStorage) track(mType, name, agent string, value float64) {
+	key := historyKey(mType, name)
+	bySource := s.sources[key]
+	if bySource == nil {
+		bySource = make(map[string]*source)
+		s.sources[key] = bySource
+	}
+	src := bySource[agent]
+	if src == nil {
+		src = &source{}
+		bySource[agent] = src
+	}
+
+	if mType == CounterType {
+		src.counter += Counter(value)
+	} else {
+		src.gauge = Gauge(value)
+	}
+	src.history = appendSample(src.history, src.value(mType))
+}
+
+// untrack забывает ряды метрики, значение которой задано целиком
+// (удаление, сброс, restore, import), вызывается под s.mu. Значение
+// счётчика остаётся в ряду без агента, чтобы сумма рядов не изменилась.
+func (s *MemStorage) untrack(mType, name string) {
+	key := historyKey(mType, name)
+	delete(s.sources, key)
+
+	if mType != CounterType {
+		return
+	}
+	if v := s.CounterStorage[name]; v > 0 {
+		s.sources[key] = map[string]*source{"": {counter: v}}
+	}
+}
+
+func seriesOf(mType, name, agent string, value float64) Metrics {
+	m := Metrics{ID: name, MType: mType}
+	if agent != "" {
+		m.Labels = map[string]string{LabelAgent: agent}
+	}
+	switch mType {
+	case CounterType:
+		delta := int64(value)
+		m.Delta = &delta
+	case GaugeType:
+		m.Value = &value
+	}
+	return m
+}
+
+// Series возвращает ряды по агентам: метрику, которую писали несколько
+// агентов, - по ряду на каждого с меткой agent. Запись без агента и
+// значения, заданные целиком, попадают в ряд без метки.
+func (s *MemStorage) Series() []Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make([]Metrics, 0, len(s.CounterStorage)+len(s.GaugeStorage))
+	add := func(mType, name string, value float64) {
+		bySource := s.sources[historyKey(mType, name)]
+		if len(bySource) == 0 {
+			all = append(all, seriesOf(mType, name, "", value))
+			return
+		}
+		for agent, src := range bySource {
+			all = append(all, seriesOf(mType, name, agent, src.value(mType)))
+		}
+	}
+
+	for name, v := range s.CounterStorage {
+		add(CounterType, name, float64(v))
+	}
+	for name, v := range s.GaugeStorage {
+		add(GaugeType, name, float64(v))
+	}
+
+	return all
+}
+
+// GetSeries возвращает текущее значение ряда агента.
+func (s *MemStorage) GetSeries(mType, name, agent string) (Metrics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.sources[historyKey(mType, name)][agent]
+	if !ok {
+		return Metrics{}, ErrNotFound
+	}
+	return seriesOf(mType, name, agent, src.value(mType)), nil
+}
+
+// SeriesHistory возвращает копию истории ряда агента. У метрики без
+// рядов история ряда без агента - её общая история.
+func (s *MemStorage) SeriesHistory(mType, name, agent string) []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := historyKey(mType, name)
+	h := s.history[key]
+	if bySource := s.sources[key]; len(bySource) > 0 || agent != "" {
+		h = nil
+		if src, ok := bySource[agent]; ok {
+			h = src.history
+		}
+	}
+
+	res := make([]Sample, len(h))
+	copy(res, h)
+	return res
+}
@@ -2,8 +2,11 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
+	"path"
 	"sync"
+	"time"
 )
 
 type Gauge float64
@@ -12,7 +15,11 @@ type Counter uint64
 type MemStorage struct {
 	CounterStorage map[string]Counter `json:"counter"`
 	GaugeStorage   map[string]Gauge   `json:"gauge"`
+	history        map[string][]Sample
+	sources        map[string]map[string]*source
 	mu             *sync.Mutex
+	// lastSave - время последнего сохранения в файл
+	lastSave time.Time
 }
 
 type Metrics struct {
@@ -20,6 +27,8 @@ type Metrics struct {
 	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
 	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
 	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	// Labels - метки ряда (LabelAgent), есть только у рядов из Series
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 const (
@@ -27,6 +36,12 @@ const (
 	GaugeType   = "gauge"
 )
 
+var (
+	ErrBadType  = errors.New("bad metric's type")
+	ErrNoValue  = errors.New("metric's value is missing")
+	ErrNotFound = errors.New("metric not found")
+)
+
 var (
 	instance *MemStorage
 	once     sync.Once
@@ -37,6 +52,8 @@ func NewMemStorage() *MemStorage {
 		instance = &MemStorage{
 			CounterStorage: make(map[string]Counter),
 			GaugeStorage:   make(map[string]Gauge),
+			history:        make(map[string][]Sample),
+			sources:        make(map[string]map[string]*source),
 			mu:             &sync.Mutex{},
 		}
 	})
@@ -45,26 +62,266 @@ func NewMemStorage() *MemStorage {
 }
 
 func (s *MemStorage) UpdateGauge(name string, value Gauge) {
+	s.UpdateGaugeFrom("", name, value)
+}
+
+// UpdateGaugeFrom записывает gauge от агента agent, см. Series.
+func (s *MemStorage) UpdateGaugeFrom(agent, name string, value Gauge) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.GaugeStorage[name] = value
+	s.record(GaugeType, name, float64(value))
+	s.track(GaugeType, name, agent, float64(value))
 }
 
 func (s *MemStorage) IncrementCounter(name string, value Counter) {
+	s.IncrementCounterFrom("", name, value)
+}
+
+// IncrementCounterFrom увеличивает счётчик от агента agent, см. Series.
+func (s *MemStorage) IncrementCounterFrom(agent, name string, value Counter) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.CounterStorage[name] += value
+	s.record(CounterType, name, float64(s.CounterStorage[name]))
+	s.track(CounterType, name, agent, float64(value))
+}
+
+func (s *MemStorage) GetCounter(name string) (Counter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.CounterStorage[name]
+	return v, ok
+}
+
+func (s *MemStorage) GetGauge(name string) (Gauge, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.GaugeStorage[name]
+	return v, ok
+}
+
+// Validate проверяет, что метрику можно записать в хранилище.
+func (m Metrics) Validate() error {
+	switch m.MType {
+	case CounterType:
+		if m.Delta == nil {
+			return ErrNoValue
+		}
+	case GaugeType:
+		if m.Value == nil {
+			return ErrNoValue
+		}
+	default:
+		return ErrBadType
+	}
+
+	return nil
+}
+
+// ApplyMetrics проверяет все метрики и только потом записывает их.
+// Возвращает значения метрик после записи.
+func (s *MemStorage) ApplyMetrics(metrics []Metrics) ([]Metrics, error) {
+	return s.ApplyMetricsFrom("", metrics)
+}
+
+// ApplyMetricsFrom - ApplyMetrics от агента agent, см. Series.
+func (s *MemStorage) ApplyMetricsFrom(agent string, metrics []Metrics) ([]Metrics, error) {
+	for _, m := range metrics {
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	applied := make([]Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		applied = append(applied, s.apply(agent, m))
+	}
+
+	return applied, nil
+}
+
+// apply записывает проверенную метрику, вызывается под s.mu.
+func (s *MemStorage) apply(agent string, m Metrics) Metrics {
+	res := Metrics{ID: m.ID, MType: m.MType}
+
+	switch m.MType {
+	case CounterType:
+		s.CounterStorage[m.ID] += Counter(*m.Delta)
+		s.record(CounterType, m.ID, float64(s.CounterStorage[m.ID]))
+		s.track(CounterType, m.ID, agent, float64(*m.Delta))
+		delta := int64(s.CounterStorage[m.ID])
+		res.Delta = &delta
+	case GaugeType:
+		s.GaugeStorage[m.ID] = Gauge(*m.Value)
+		s.record(GaugeType, m.ID, *m.Value)
+		s.track(GaugeType, m.ID, agent, *m.Value)
+		value := *m.Value
+		res.Value = &value
+	}
+
+	return res
+}
+
+// GetMetric возвращает текущее значение метрики.
+func (s *MemStorage) GetMetric(mType, name string) (Metrics, error) {
+	m := Metrics{ID: name, MType: mType}
+
+	switch mType {
+	case CounterType:
+		v, ok := s.GetCounter(name)
+		if !ok {
+			return m, ErrNotFound
+		}
+		delta := int64(v)
+		m.Delta = &delta
+	case GaugeType:
+		v, ok := s.GetGauge(name)
+		if !ok {
+			return m, ErrNotFound
+		}
+		value := float64(v)
+		m.Value = &value
+	default:
+		return m, ErrBadType
+	}
+
+	return m, nil
+}
+
+// Len возвращает число счётчиков и gauge в хранилище.
+func (s *MemStorage) Len() (counters, gauges int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.CounterStorage), len(s.GaugeStorage)
+}
+
+// All возвращает копию всех метрик хранилища в формате Metrics.
+func (s *MemStorage) All() []Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make([]Metrics, 0, len(s.CounterStorage)+len(s.GaugeStorage))
+
+	for name, v := range s.CounterStorage {
+		delta := int64(v)
+		all = append(all, Metrics{ID: name, MType: CounterType, Delta: &delta})
+	}
+
+	for name, v := range s.GaugeStorage {
+		value := float64(v)
+		all = append(all, Metrics{ID: name, MType: GaugeType, Value: &value})
+	}
+
+	return all
+}
+
+// DeleteCounter удаляет счётчик, возвращает false, если его не было.
+func (s *MemStorage) DeleteCounter(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.CounterStorage[name]; !ok {
+		return false
+	}
+	delete(s.CounterStorage, name)
+	delete(s.history, historyKey(CounterType, name))
+	s.untrack(CounterType, name)
+	return true
+}
+
+// DeleteGauge удаляет gauge, возвращает false, если его не было.
+func (s *MemStorage) DeleteGauge(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.GaugeStorage[name]; !ok {
+		return false
+	}
+	delete(s.GaugeStorage, name)
+	delete(s.history, historyKey(GaugeType, name))
+	s.untrack(GaugeType, name)
+	return true
+}
+
+// DeleteMatched удаляет все метрики, имя которых подходит под glob-шаблон
+// (синтаксис path.Match). Пустой mType означает метрики обоих типов.
+// Возвращает удалённые метрики с их последними значениями.
+func (s *MemStorage) DeleteMatched(mType, pattern string) ([]Metrics, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make([]Metrics, 0)
+
+	if mType == "" || mType == CounterType {
+		for name, v := range s.CounterStorage {
+			if ok, _ := path.Match(pattern, name); ok {
+				delta := int64(v)
+				deleted = append(deleted, Metrics{ID: name, MType: CounterType, Delta: &delta})
+				delete(s.CounterStorage, name)
+				delete(s.history, historyKey(CounterType, name))
+				s.untrack(CounterType, name)
+			}
+		}
+	}
+
+	if mType == "" || mType == GaugeType {
+		for name, v := range s.GaugeStorage {
+			if ok, _ := path.Match(pattern, name); ok {
+				value := float64(v)
+				deleted = append(deleted, Metrics{ID: name, MType: GaugeType, Value: &value})
+				delete(s.GaugeStorage, name)
+				delete(s.history, historyKey(GaugeType, name))
+				s.untrack(GaugeType, name)
+			}
+		}
+	}
+
+	return deleted, nil
+}
+
+// ResetCounter обнуляет существующий счётчик, возвращает false, если его не было.
+func (s *MemStorage) ResetCounter(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.CounterStorage[name]; !ok {
+		return false
+	}
+	s.CounterStorage[name] = 0
+	s.record(CounterType, name, 0)
+	s.untrack(CounterType, name)
+	return true
 }
 
 func (s *MemStorage) ToFile(f string) error {
+	s.mu.Lock()
 	data, err := json.MarshalIndent(s, "", "   ")
+	s.mu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(f, data, 0644)
+	if err = os.WriteFile(f, data, 0644); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.lastSave = time.Now()
+	s.mu.Unlock()
+	return nil
 }
 
 func (s *MemStorage) FromFile(f string) error {
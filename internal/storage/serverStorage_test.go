@@ -1,6 +1,9 @@
 package storage
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestServerStorage_UpdateGauge(t *testing.T) {
 	type testType struct {
@@ -86,3 +89,180 @@ func TestServerStorage_IncrementCounter(t *testing.T) {
 		}
 	}
 }
+
+func TestServerStorage_DeleteMatched(t *testing.T) {
+	type testType struct {
+		name        string
+		mType       string
+		pattern     string
+		expectedIDs []string
+		wantErr     bool
+	}
+
+	tests := []testType{
+		{
+			name:        "Delete gauges by prefix",
+			mType:       GaugeType,
+			pattern:     "delHeap*",
+			expectedIDs: []string{"delHeapAlloc", "delHeapSys"},
+		},
+		{
+			name:        "Delete both types",
+			mType:       "",
+			pattern:     "delPoll?",
+			expectedIDs: []string{"delPollA", "delPollB"},
+		},
+		{
+			name:        "Nothing matched",
+			mType:       CounterType,
+			pattern:     "delHeap*",
+			expectedIDs: []string{},
+		},
+		{
+			name:    "Bad pattern",
+			pattern: "del[",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewMemStorage()
+			s.UpdateGauge("delHeapAlloc", 1)
+			s.UpdateGauge("delHeapSys", 2)
+			s.UpdateGauge("delPollA", 3)
+			s.IncrementCounter("delPollB", 4)
+			defer s.DeleteMatched("", "del*")
+
+			deleted, err := s.DeleteMatched(test.mType, test.pattern)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("DeleteMatched() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DeleteMatched() error = %v", err)
+			}
+
+			got := make(map[string]bool)
+			for _, m := range deleted {
+				got[m.ID] = true
+			}
+			if len(got) != len(test.expectedIDs) {
+				t.Errorf("DeleteMatched() deleted %v, want %v", got, test.expectedIDs)
+			}
+			for _, id := range test.expectedIDs {
+				if !got[id] {
+					t.Errorf("DeleteMatched() did not delete %s", id)
+				}
+				if _, ok := s.GetGauge(id); ok {
+					t.Errorf("DeleteMatched() gauge %s still exists", id)
+				}
+				if _, ok := s.GetCounter(id); ok {
+					t.Errorf("DeleteMatched() counter %s still exists", id)
+				}
+			}
+		})
+	}
+}
+
+func TestServerStorage_ResetCounter(t *testing.T) {
+	s := NewMemStorage()
+	s.IncrementCounter("resetCounter", 10)
+	defer s.DeleteCounter("resetCounter")
+
+	if !s.ResetCounter("resetCounter") {
+		t.Fatalf("ResetCounter() = false, want true")
+	}
+	if v, _ := s.GetCounter("resetCounter"); v != 0 {
+		t.Errorf("ResetCounter() value = %v, want 0", v)
+	}
+	if s.ResetCounter("missingCounter") {
+		t.Errorf("ResetCounter() on missing counter = true, want false")
+	}
+}
+
+func TestServerStorage_History(t *testing.T) {
+	s := NewMemStorage()
+	defer s.DeleteCounter("historyCounter")
+
+	for i := 0; i < historySize+10; i++ {
+		s.IncrementCounter("historyCounter", 1)
+	}
+
+	h := s.History(CounterType, "historyCounter")
+	if len(h) != historySize {
+		t.Fatalf("History() len = %d, want %d", len(h), historySize)
+	}
+	if h[len(h)-1].Value != historySize+10 {
+		t.Errorf("History() last = %v, want %v", h[len(h)-1].Value, historySize+10)
+	}
+
+	s.DeleteCounter("historyCounter")
+	if len(s.History(CounterType, "historyCounter")) != 0 {
+		t.Errorf("History() is not empty after delete")
+	}
+}
+
+func TestServerStorage_Series(t *testing.T) {
+	s := NewMemStorage()
+	defer s.DeleteCounter("seriesCounter")
+	defer s.DeleteGauge("seriesGauge")
+
+	s.UpdateGaugeFrom("host1", "seriesGauge", 1)
+	s.UpdateGaugeFrom("host2", "seriesGauge", 2)
+	s.IncrementCounterFrom("host1", "seriesCounter", 3)
+	if _, err := s.ApplyMetricsFrom("host2", []Metrics{{ID: "seriesCounter", MType: CounterType, Delta: new(int64)}}); err != nil {
+		t.Fatalf("ApplyMetricsFrom() error = %v", err)
+	}
+	s.IncrementCounter("seriesCounter", 4)
+
+	series := func() map[string]float64 {
+		res := make(map[string]float64)
+		for _, m := range s.Series() {
+			if m.ID != "seriesGauge" && m.ID != "seriesCounter" {
+				continue
+			}
+			v := 0.0
+			if m.Delta != nil {
+				v = float64(*m.Delta)
+			}
+			if m.Value != nil {
+				v = *m.Value
+			}
+			res[m.ID+"/"+m.Labels[LabelAgent]] = v
+		}
+		return res
+	}
+
+	want := map[string]float64{
+		"seriesGauge/host1":   1,
+		"seriesGauge/host2":   2,
+		"seriesCounter/host1": 3,
+		"seriesCounter/host2": 0,
+		"seriesCounter/":      4,
+	}
+	if got := series(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Series() = %v, want %v", got, want)
+	}
+	if v, _ := s.GetGauge("seriesGauge"); v != 2 {
+		t.Errorf("GetGauge() = %v, want last written 2", v)
+	}
+
+	if h := s.SeriesHistory(GaugeType, "seriesGauge", "host1"); len(h) != 1 || h[0].Value != 1 {
+		t.Errorf("SeriesHistory() = %v, want one sample 1", h)
+	}
+	if m, err := s.GetSeries(CounterType, "seriesCounter", "host1"); err != nil || *m.Delta != 3 {
+		t.Errorf("GetSeries() = %v, %v, want 3", m, err)
+	}
+
+	// Сброс забывает агентов, значение счётчика остаётся в ряду без метки
+	s.ResetCounter("seriesCounter")
+	s.IncrementCounterFrom("host1", "seriesCounter", 5)
+	s.DeleteGauge("seriesGauge")
+	want = map[string]float64{"seriesCounter/host1": 5}
+	if got := series(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Series() after reset = %v, want %v", got, want)
+	}
+}
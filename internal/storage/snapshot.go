@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/pavelborisofff/go-metrics/internal/selfmetrics"
+	"github.com/pavelborisofff/go-metrics/internal/tracing"
+)
+
+// Save сохраняет хранилище в файл и возвращает его размер. Время и размер
+// учитываются в selfmetrics.
+func (s *MemStorage) Save(ctx context.Context, file string) (size int64, err error) {
+	_, span := tracing.Start(ctx, "storage.ToFile")
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	if err = s.ToFile(file); err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	selfmetrics.Default.ObserveSnapshot(time.Since(start), info.Size())
+	return info.Size(), nil
+}
+
+// Restore заменяет содержимое хранилища снимком из file. Файл разбирается
+// целиком до замены, так что при ошибке хранилище не меняется. История
+// значений и ряды агентов сбрасываются.
+func (s *MemStorage) Restore(ctx context.Context, file string) (err error) {
+	_, span := tracing.Start(ctx, "storage.Restore")
+	defer func() { tracing.End(span, err) }()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var snap struct {
+		CounterStorage map[string]Counter `json:"counter"`
+		GaugeStorage   map[string]Gauge   `json:"gauge"`
+	}
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if snap.CounterStorage == nil {
+		snap.CounterStorage = make(map[string]Counter)
+	}
+	if snap.GaugeStorage == nil {
+		snap.GaugeStorage = make(map[string]Gauge)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.CounterStorage, s.GaugeStorage = snap.CounterStorage, snap.GaugeStorage
+	s.history = make(map[string][]Sample)
+	s.sources = make(map[string]map[string]*source)
+	for name := range s.CounterStorage {
+		s.untrack(CounterType, name)
+	}
+	return nil
+}
+
+// Stats - сводка о содержимом хранилища.
+type Stats struct {
+	Counters       int `json:"counters"`
+	Gauges         int `json:"gauges"`
+	HistorySamples int `json:"history_samples"`
+	// MemoryBytes - примерная оценка памяти под значения, имена и историю
+	// без накладных расходов map
+	MemoryBytes int64 `json:"memory_bytes"`
+	// LastSave - время последнего сохранения в файл, nil если его не было
+	LastSave *time.Time `json:"last_save"`
+}
+
+func (s *MemStorage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Stats{Counters: len(s.CounterStorage), Gauges: len(s.GaugeStorage)}
+
+	var mem int64
+	for name := range s.CounterStorage {
+		mem += int64(len(name)) + int64(unsafe.Sizeof(Counter(0)))
+	}
+	for name := range s.GaugeStorage {
+		mem += int64(len(name)) + int64(unsafe.Sizeof(Gauge(0)))
+	}
+	for key, h := range s.history {
+		st.HistorySamples += len(h)
+		mem += int64(len(key)) + int64(cap(h))*int64(unsafe.Sizeof(Sample{}))
+	}
+	for _, bySource := range s.sources {
+		for agent, src := range bySource {
+			st.HistorySamples += len(src.history)
+			mem += int64(len(agent)) + int64(unsafe.Sizeof(source{})) + int64(cap(src.history))*int64(unsafe.Sizeof(Sample{}))
+		}
+	}
+	st.MemoryBytes = mem
+
+	if !s.lastSave.IsZero() {
+		t := s.lastSave
+		st.LastSave = &t
+	}
+	return st
+}
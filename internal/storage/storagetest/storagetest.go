@@ -0,0 +1,58 @@
+// Package storagetest - заготовки хранилища для тестов пакетов, которые
+// читают его через storage.Reader.
+package storagetest
+
+import (
+	"time"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+// Now - момент, от которого At отсчитывает время значений истории.
+var Now = time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+
+// Reader - storage.Reader над заданными рядами. All и Series отдают
+// Metrics как есть. История лежит в Samples по ключу type/name, у ряда
+// агента - type/name/agent.
+type Reader struct {
+	Metrics []storage.Metrics
+	Samples map[string][]storage.Sample
+}
+
+func (r *Reader) All() []storage.Metrics {
+	return r.Metrics
+}
+
+func (r *Reader) History(mType, name string) []storage.Sample {
+	return r.Samples[mType+"/"+name]
+}
+
+func (r *Reader) Series() []storage.Metrics {
+	return r.Metrics
+}
+
+func (r *Reader) SeriesHistory(mType, name, agent string) []storage.Sample {
+	if agent == "" {
+		return r.History(mType, name)
+	}
+	return r.Samples[mType+"/"+name+"/"+agent]
+}
+
+func Gauge(id string, v float64) storage.Metrics {
+	return storage.Metrics{ID: id, MType: storage.GaugeType, Value: &v}
+}
+
+func Counter(id string, v int64) storage.Metrics {
+	return storage.Metrics{ID: id, MType: storage.CounterType, Delta: &v}
+}
+
+// On возвращает m как ряд агента agent.
+func On(agent string, m storage.Metrics) storage.Metrics {
+	m.Labels = map[string]string{storage.LabelAgent: agent}
+	return m
+}
+
+// At - значение истории через sec секунд после Now.
+func At(sec int, v float64) storage.Sample {
+	return storage.Sample{Time: Now.Add(time.Duration(sec) * time.Second), Value: v}
+}
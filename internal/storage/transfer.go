@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pavelborisofff/go-metrics/internal/selfmetrics"
+)
+
+// Режимы импорта: merge дописывает и перезаписывает метрики из выгрузки,
+// replace дополнительно удаляет всё, чего в выгрузке нет, кроме служебных
+// метрик selfmetrics.Prefix.
+const (
+	ImportMerge   = "merge"
+	ImportReplace = "replace"
+)
+
+var (
+	ErrDuplicate       = errors.New("duplicate metric")
+	ErrNegativeCounter = errors.New("counter value must not be negative")
+)
+
+// LineError - ошибка разбора строки выгрузки, Line считается с 1.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// ImportReport - что импорт изменил или, при DryRun, изменил бы.
+type ImportReport struct {
+	Mode      string `json:"mode"`
+	DryRun    bool   `json:"dry_run"`
+	Total     int    `json:"total"`
+	Added     int    `json:"added"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	Removed   int    `json:"removed"`
+	Skipped   int    `json:"skipped,omitempty"`
+}
+
+// sortMetrics упорядочивает метрики по типу и имени, чтобы выгрузки
+// одного состояния совпадали байт в байт.
+func sortMetrics(all []Metrics) {
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].MType != all[j].MType {
+			return all[i].MType < all[j].MType
+		}
+		return all[i].ID < all[j].ID
+	})
+}
+
+// Export пишет все метрики, кроме служебных selfmetrics.Prefix, в w по
+// одной JSON-строке (NDJSON) и возвращает их число. Значения копируются
+// под блокировкой, запись идёт без неё.
+func (s *MemStorage) Export(w io.Writer) (int, error) {
+	all := s.All()
+	sortMetrics(all)
+
+	enc := json.NewEncoder(w)
+	n := 0
+	for _, m := range all {
+		if strings.HasPrefix(m.ID, selfmetrics.Prefix) {
+			continue
+		}
+		if err := enc.Encode(m); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ReadMetricsLines разбирает NDJSON-выгрузку. Пустые строки пропускаются,
+// каждая метрика проверяется Validate, повтор метрики - ошибка.
+// Ошибки возвращаются как *LineError.
+func ReadMetricsLines(r io.Reader) ([]Metrics, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	metrics := make([]Metrics, 0)
+	seen := make(map[string]struct{})
+	line := 0
+	for sc.Scan() {
+		line++
+		data := sc.Bytes()
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		var m Metrics
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, &LineError{Line: line, Err: err}
+		}
+		if m.ID == "" {
+			return nil, &LineError{Line: line, Err: errors.New("metric's id is missing")}
+		}
+		if err := m.Validate(); err != nil {
+			return nil, &LineError{Line: line, Err: err}
+		}
+		// Счётчик хранится без знака, отрицательное значение стало бы огромным
+		if m.MType == CounterType && *m.Delta < 0 {
+			return nil, &LineError{Line: line, Err: ErrNegativeCounter}
+		}
+
+		key := historyKey(m.MType, m.ID)
+		if _, ok := seen[key]; ok {
+			return nil, &LineError{Line: line, Err: fmt.Errorf("%w: %s", ErrDuplicate, key)}
+		}
+		seen[key] = struct{}{}
+		metrics = append(metrics, m)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, &LineError{Line: line + 1, Err: err}
+	}
+
+	return metrics, nil
+}
+
+// Import записывает проверенные ReadMetricsLines метрики. В отличие от
+// обновлений, значение счётчика не прибавляется, а устанавливается как
+// есть: выгрузка переносит состояние. При dryRun хранилище не меняется,
+// отчёт показывает, что было бы сделано.
+func (s *MemStorage) Import(metrics []Metrics, mode string, dryRun bool) (ImportReport, error) {
+	if mode == "" {
+		mode = ImportMerge
+	}
+	if mode != ImportMerge && mode != ImportReplace {
+		return ImportReport{}, fmt.Errorf("unknown import mode: %s", mode)
+	}
+
+	rep := ImportReport{Mode: mode, DryRun: dryRun, Total: len(metrics)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incoming := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		incoming[historyKey(m.MType, m.ID)] = struct{}{}
+
+		var exists, same bool
+		switch m.MType {
+		case CounterType:
+			var v Counter
+			v, exists = s.CounterStorage[m.ID]
+			same = exists && v == Counter(*m.Delta)
+		case GaugeType:
+			var v Gauge
+			v, exists = s.GaugeStorage[m.ID]
+			same = exists && v == Gauge(*m.Value)
+		}
+
+		switch {
+		case same:
+			rep.Unchanged++
+			continue
+		case exists:
+			rep.Updated++
+		default:
+			rep.Added++
+		}
+
+		if dryRun {
+			continue
+		}
+		switch m.MType {
+		case CounterType:
+			s.CounterStorage[m.ID] = Counter(*m.Delta)
+			s.record(CounterType, m.ID, float64(Counter(*m.Delta)))
+			s.untrack(CounterType, m.ID)
+		case GaugeType:
+			s.GaugeStorage[m.ID] = Gauge(*m.Value)
+			s.record(GaugeType, m.ID, *m.Value)
+			s.untrack(GaugeType, m.ID)
+		}
+	}
+
+	if mode != ImportReplace {
+		return rep, nil
+	}
+
+	// Служебные метрики сервер пишет сам, Export их не выгружает
+	keep := func(mType, name string) bool {
+		_, ok := incoming[historyKey(mType, name)]
+		return ok || strings.HasPrefix(name, selfmetrics.Prefix)
+	}
+	for name := range s.CounterStorage {
+		if !keep(CounterType, name) {
+			rep.Removed++
+			if !dryRun {
+				delete(s.CounterStorage, name)
+				delete(s.history, historyKey(CounterType, name))
+				s.untrack(CounterType, name)
+			}
+		}
+	}
+	for name := range s.GaugeStorage {
+		if !keep(GaugeType, name) {
+			rep.Removed++
+			if !dryRun {
+				delete(s.GaugeStorage, name)
+				delete(s.history, historyKey(GaugeType, name))
+				s.untrack(GaugeType, name)
+			}
+		}
+	}
+
+	return rep, nil
+}
@@ -0,0 +1,212 @@
+package stream
+
+import (
+	"path"
+	"sync"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+)
+
+// Filter отбирает обновления для подписчика. Пустые поля не фильтруют.
+type Filter struct {
+	Name   string // glob-шаблон имени (синтаксис path.Match)
+	MType  string
+	Labels map[string]string // метки ряда (storage.LabelAgent)
+}
+
+// Match сообщает, проходит ли метрика фильтр.
+func (f Filter) Match(m storage.Metrics) bool {
+	if f.MType != "" && f.MType != m.MType {
+		return false
+	}
+	if f.Name != "" {
+		if ok, _ := path.Match(f.Name, m.ID); !ok {
+			return false
+		}
+	}
+	// Удаление касается всех рядов метрики
+	if IsRemoved(m) {
+		return true
+	}
+	for k, v := range f.Labels {
+		if m.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Removed возвращает событие удаления метрики: метрику без значения.
+func Removed(mType, name string) storage.Metrics {
+	return storage.Metrics{ID: name, MType: mType}
+}
+
+// IsRemoved сообщает, что событие - удаление метрики.
+func IsRemoved(m storage.Metrics) bool {
+	return m.Delta == nil && m.Value == nil
+}
+
+// bufSize - размер буфера подписчика общего Hub.
+const bufSize = 256
+
+var (
+	instance *Hub
+	once     sync.Once
+)
+
+// Subscriber получает обновления из C, удаление метрики приходит как
+// событие без значения (IsRemoved). Сигнал в Resync значит, что
+// хранилище изменилось целиком: обновления из C до сигнала устарели,
+// текущее состояние нужно перечитать из хранилища.
+type Subscriber struct {
+	C      <-chan storage.Metrics
+	Resync <-chan struct{}
+	ch     chan storage.Metrics
+	resync chan struct{}
+	filter Filter
+}
+
+// Hub рассылает обновления метрик подписчикам. Publish никогда не блокируется:
+// подписчик, у которого заполнен буфер, отключается, а его канал закрывается.
+// Shutdown закрывает Done, по нему длинные потоки завершаются при
+// остановке сервера.
+type Hub struct {
+	mu       sync.Mutex
+	subs     map[*Subscriber]struct{}
+	bufSize  int
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewHub(bufSize int) *Hub {
+	return &Hub{
+		subs:    make(map[*Subscriber]struct{}),
+		bufSize: bufSize,
+		done:    make(chan struct{}),
+	}
+}
+
+// GetHub возвращает Hub, общий для всех транспортов сервера.
+func GetHub() *Hub {
+	once.Do(func() {
+		instance = NewHub(bufSize)
+	})
+
+	return instance
+}
+
+func (h *Hub) Subscribe(f Filter) *Subscriber {
+	ch := make(chan storage.Metrics, h.bufSize)
+	resync := make(chan struct{}, 1)
+	sub := &Subscriber{C: ch, Resync: resync, ch: ch, resync: resync, filter: f}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe отключает подписчика, повторный вызов безопасен.
+func (h *Hub) Unsubscribe(sub *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (h *Hub) Publish(m storage.Metrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		if !sub.filter.Match(m) {
+			continue
+		}
+
+		select {
+		case sub.ch <- m:
+		default:
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// PublishAll рассылает пачку обновлений. Подписчику, в буфер которого
+// пачка не помещается, вместо неё отправляется resync, так что пачка не
+// отключает подписчиков.
+func (h *Hub) PublishAll(metrics []storage.Metrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		matched := make([]storage.Metrics, 0, len(metrics))
+		for _, m := range metrics {
+			if sub.filter.Match(m) {
+				matched = append(matched, m)
+			}
+		}
+
+		if len(matched) > cap(sub.ch)-len(sub.ch) {
+			sub.signalResync()
+			continue
+		}
+		for _, m := range matched {
+			sub.ch <- m
+		}
+	}
+}
+
+// Resync сообщает подписчикам, что хранилище изменилось целиком (restore,
+// import). Вместо рассылки каждой метрики, которая переполнила бы буферы,
+// подписчикам отправляется один сигнал, повторные сигналы сливаются.
+// Накопленные обновления отбрасываются: подписчик перечитает хранилище.
+func (h *Hub) Resync() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		sub.signalResync()
+	}
+}
+
+// signalResync вызывается под h.mu, поэтому в ch никто не пишет.
+func (sub *Subscriber) signalResync() {
+drain:
+	for {
+		select {
+		case <-sub.ch:
+		default:
+			break drain
+		}
+	}
+
+	select {
+	case sub.resync <- struct{}{}:
+	default:
+	}
+}
+
+// Shutdown сообщает подписчикам через Done, что сервер останавливается.
+// Повторный вызов безопасен.
+func (h *Hub) Shutdown() {
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// Done закрывается при Shutdown.
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subs)
+}
@@ -0,0 +1,98 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pavelborisofff/go-metrics/internal/storage"
+	"github.com/pavelborisofff/go-metrics/internal/storage/storagetest"
+)
+
+var gauge = storagetest.Gauge
+
+func TestHub_Filter(t *testing.T) {
+	h := NewHub(10)
+	heap := h.Subscribe(Filter{Name: "Heap*"})
+	counters := h.Subscribe(Filter{MType: storage.CounterType})
+	defer h.Unsubscribe(heap)
+	defer h.Unsubscribe(counters)
+
+	h.Publish(gauge("HeapAlloc", 1))
+	h.Publish(gauge("Alloc", 2))
+
+	assert.Len(t, heap.C, 1)
+	assert.Equal(t, "HeapAlloc", (<-heap.C).ID)
+	assert.Len(t, counters.C, 0)
+}
+
+func TestHub_DropSlowSubscriber(t *testing.T) {
+	h := NewHub(1)
+	slow := h.Subscribe(Filter{})
+	fast := h.Subscribe(Filter{})
+	defer h.Unsubscribe(fast)
+
+	h.Publish(gauge("Alloc", 1))
+	<-fast.C
+	h.Publish(gauge("Alloc", 2))
+
+	assert.Equal(t, 1, h.Len(), "slow subscriber must be dropped")
+
+	<-slow.C
+	_, ok := <-slow.C
+	assert.False(t, ok, "channel of dropped subscriber must be closed")
+
+	h.Unsubscribe(slow)
+}
+
+func TestHub_Resync(t *testing.T) {
+	h := NewHub(2)
+	sub := h.Subscribe(Filter{})
+	defer h.Unsubscribe(sub)
+
+	h.Publish(gauge("Alloc", 1))
+	h.Publish(gauge("Alloc", 2))
+	for i := 0; i < 10; i++ {
+		h.Resync()
+	}
+
+	assert.Equal(t, 1, h.Len(), "resync must not drop subscribers")
+	assert.Len(t, sub.C, 0, "stale updates must be discarded")
+	assert.Len(t, sub.Resync, 1, "resyncs must coalesce")
+
+	h.Publish(gauge("Alloc", 3))
+	assert.Equal(t, 3.0, *(<-sub.C).Value)
+}
+
+func TestHub_Shutdown(t *testing.T) {
+	h := NewHub(1)
+	select {
+	case <-h.Done():
+		t.Fatal("hub is done before shutdown")
+	default:
+	}
+
+	h.Shutdown()
+	h.Shutdown()
+	_, ok := <-h.Done()
+	assert.False(t, ok)
+}
+
+func TestHub_PublishAll(t *testing.T) {
+	h := NewHub(2)
+	small := h.Subscribe(Filter{Name: "Heap*"})
+	all := h.Subscribe(Filter{})
+	defer h.Unsubscribe(small)
+	defer h.Unsubscribe(all)
+
+	h.PublishAll([]storage.Metrics{gauge("HeapAlloc", 1), Removed(storage.GaugeType, "Alloc"), gauge("Sys", 3)})
+
+	assert.Equal(t, 2, h.Len(), "batch must not drop subscribers")
+	assert.Len(t, small.C, 1)
+	assert.Len(t, small.Resync, 0)
+	assert.Len(t, all.C, 0, "batch larger than buffer turns into resync")
+	assert.Len(t, all.Resync, 1)
+
+	assert.True(t, IsRemoved(Removed(storage.GaugeType, "Alloc")))
+	assert.False(t, IsRemoved(gauge("Alloc", 0)))
+}
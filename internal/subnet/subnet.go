@@ -0,0 +1,93 @@
+package subnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Header - заголовок, в котором агент передаёт свой IP.
+	Header = "X-Real-IP"
+	// MetadataKey - то же для gRPC, ключи метаданных в нижнем регистре.
+	MetadataKey = "x-real-ip"
+)
+
+// Parse разбирает подсеть в CIDR-нотации, пустая строка - проверка выключена.
+func Parse(cidr string) (*net.IPNet, error) {
+	if cidr == "" {
+		return nil, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("bad trusted subnet %q: %w", cidr, err)
+	}
+	return ipNet, nil
+}
+
+// Trusted проверяет, что ip входит в подсеть. Без подсети доверяем всем.
+func Trusted(trusted *net.IPNet, ip string) bool {
+	if trusted == nil {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	return parsed != nil && trusted.Contains(parsed)
+}
+
+// Handle отклоняет с 403 запросы, чей X-Real-IP не входит в подсеть.
+func Handle(trusted *net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !Trusted(trusted, r.Header.Get(Header)) {
+				http.Error(w, "Forbidden: untrusted IP", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// UnaryInterceptor проверяет x-real-ip у перечисленных методов gRPC.
+func UnaryInterceptor(trusted *net.IPNet, methods ...string) grpc.UnaryServerInterceptor {
+	guarded := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		guarded[m] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if guarded[info.FullMethod] {
+			var ip string
+			if md, ok := metadata.FromIncomingContext(ctx); ok {
+				if v := md.Get(MetadataKey); len(v) > 0 {
+					ip = v[0]
+				}
+			}
+
+			if !Trusted(trusted, ip) {
+				return nil, status.Error(codes.PermissionDenied, "untrusted IP")
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// OutboundIP возвращает локальный IP, с которого идут соединения к addr (host:port).
+// UDP-"соединение" не отправляет пакетов, только выбирает маршрут.
+func OutboundIP(addr string) (net.IP, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,79 @@
+package subnet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestHandle(t *testing.T) {
+	trusted, err := Parse("192.168.1.0/24")
+	require.NoError(t, err)
+
+	type testType struct {
+		name         string
+		realIP       string
+		expectedCode int
+	}
+
+	tests := []testType{
+		{name: "Inside subnet", realIP: "192.168.1.10", expectedCode: http.StatusOK},
+		{name: "Outside subnet", realIP: "10.0.0.1", expectedCode: http.StatusForbidden},
+		{name: "No header", realIP: "", expectedCode: http.StatusForbidden},
+		{name: "Garbage", realIP: "localhost", expectedCode: http.StatusForbidden},
+	}
+
+	handler := Handle(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if test.realIP != "" {
+				req.Header.Set(Header, test.realIP)
+			}
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+			assert.Equal(t, test.expectedCode, res.Code)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	trusted, err := Parse("")
+	require.NoError(t, err)
+	assert.True(t, Trusted(trusted, ""), "empty subnet must trust everyone")
+
+	_, err = Parse("192.168.1.0")
+	assert.Error(t, err)
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	trusted, err := Parse("10.0.0.0/8")
+	require.NoError(t, err)
+
+	interceptor := UnaryInterceptor(trusted, "/metrics.Metrics/UpdateMetrics")
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	call := func(method, ip string) error {
+		ctx := context.Background()
+		if ip != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, ip))
+		}
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	assert.NoError(t, call("/metrics.Metrics/UpdateMetrics", "10.1.2.3"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("/metrics.Metrics/UpdateMetrics", "172.16.0.1")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("/metrics.Metrics/UpdateMetrics", "")))
+	assert.NoError(t, call("/metrics.Metrics/GetMetric", ""), "reads are not guarded")
+}
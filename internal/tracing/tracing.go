@@ -0,0 +1,90 @@
+// Package tracing настраивает OpenTelemetry: экспорт спанов, W3C trace-context
+// между агентом и сервером и middleware для HTTP и gRPC.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const name = "github.com/pavelborisofff/go-metrics"
+
+// Экспортёры спанов
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Shutdown отправляет накопленные спаны и останавливает экспорт.
+type Shutdown func(context.Context) error
+
+// Setup включает трассировку для сервиса service. exporter - none, stdout
+// или otlp, endpoint - адрес OTLP-коллектора по gRPC (host:port).
+// При none трассировка выключена и спаны ничего не стоят.
+func Setup(service, exporter, endpoint string) (Shutdown, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(io.Writer(os.Stdout)))
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithInsecure()}
+		if endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(endpoint))
+		}
+		exp, err = otlptracegrpc.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tp := NewProvider(service, sdktrace.NewBatchSpanProcessor(exp))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// NewProvider создаёт провайдер с процессором p, в тестах - с
+// tracetest.NewInMemoryExporter. Пропагатор W3C ставится глобально.
+func NewProvider(service string, p sdktrace.SpanProcessor) *sdktrace.TracerProvider {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(p),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+	)
+}
+
+// Start начинает дочерний спан ctx в трассировщике приложения.
+func Start(ctx context.Context, spanName string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(name).Start(ctx, spanName, trace.WithAttributes(attrs...))
+}
+
+// End завершает спан, отмечая в нём ошибку err, если она есть.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestProvider(t *testing.T) *tracetest.InMemoryExporter {
+	exp := tracetest.NewInMemoryExporter()
+	tp := NewProvider("test", sdktrace.NewSimpleSpanProcessor(exp))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	return exp
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup("test", ExporterNone, "")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup("test", "jaeger", "")
+	assert.Error(t, err)
+}
+
+func TestHandle(t *testing.T) {
+	exp := newTestProvider(t)
+
+	r := chi.NewRouter()
+	r.Use(Handle)
+	r.Get("/value/{name}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "storage.Get")
+		span.End()
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	// Трасса агента приходит в traceparent
+	ctx, parent := otel.Tracer("agent").Start(context.Background(), "agent.send")
+	req := httptest.NewRequest(http.MethodGet, "/value/x", nil)
+	Inject(ctx, req.Header)
+	parent.End()
+
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exp.GetSpans() {
+		spans[s.Name] = s
+	}
+	require.Len(t, spans, 3)
+	inner, server := spans["storage.Get"], spans["GET /value/{name}"]
+
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, parent.SpanContext().TraceID(), server.SpanContext.TraceID())
+	assert.Equal(t, parent.SpanContext().SpanID(), server.Parent.SpanID())
+	assert.Equal(t, server.SpanContext.SpanID(), inner.Parent.SpanID())
+}